@@ -1,20 +1,23 @@
 // This program takes a Wordpress export XML and converts it to "Block"-style
-// blog posts.
+// blog posts, or posts for other static site generators.
 package main
 
 import (
-	"encoding/xml"
+	"bytes"
+	"flag"
 	"fmt"
 	"github.com/rygorous/wp2block/wxr"
+	"hash/crc32"
+	"html"
 	"io"
-	"io/ioutil"
 	"log"
-	"math/rand"
-	"net/http"
 	"net/url"
 	"os"
 	"path"
 	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -34,36 +37,94 @@ const (
 	StatusDraft
 	StatusPending
 	StatusPrivate
-
-	mediaPath = "wpmedia"
+	StatusFuture
 )
 
+// Options control the conversion; they're set from the command line.
+type Options struct {
+	MediaPath      string             // Media directory, relative to the output directory
+	MediaLayout    string             // "flat", or "uploads" to keep the YYYY/MM directories
+	Variants       string             // "full" to link resized images to the original, "exact" to fetch them
+	ShortlinkBlog  string             // wp.me blog id; if empty, all wp.me links are assumed to be ours
+	Pages          string             // "merge" the pages of multi-page docs, or "split" them into separate docs
+	Statuses       map[DocStatus]bool // Doc statuses to export
+	Comments       bool               // Export approved comments?
+	Pingbacks      bool               // Include pingbacks and trackbacks with comments?
+	CommentPrivate bool               // Include commenter email and IP addresses?
+	Jobs           int                // Number of concurrent downloads
+	Timeout        time.Duration      // Timeout for a single download
+	Retries        int                // Retries for downloads that fail with transient errors
+	UploadsDir     string             // Local copy of wp-content/uploads to take attachments from
+	HttpFallback   bool               // Download attachments that aren't in UploadsDir?
+	FrontMatter    string             // Front matter format for Hugo, "yaml" or "toml"
+}
+
 type Blog struct {
-	Author      Author
+	Authors     map[string]*Author   // by login
+	Categories  map[string]*Category // by slug
+	Tags        map[string]*Tag      // by slug
 	Docs        []*Doc
 	Attachments []*Attachment
+	Unresolved  []*UnresolvedLink
+	FetchErrors []error // attachments that couldn't be fetched
 }
 
 type Author struct {
+	Login string
 	Name  string
 	Email string
 }
 
+type Category struct {
+	Slug   string
+	Name   string
+	Parent *Category
+}
+
+type Tag struct {
+	Slug string
+	Name string
+}
+
 type Doc struct {
 	Id              string
 	Title           string
+	Link            string   // permalink on the Wordpress site
+	Aliases         []string // other URLs of the doc on the Wordpress site
+	Author          *Author
+	Parent          *Doc   // for hierarchical pages
 	Content         []byte // output markdown
-	ContentHtml     []byte // original HTML code
+	ContentHtml     []byte // original HTML code, only set while the doc is converted
+	Excerpt         []byte // output markdown; the part before the "more" marker if there's no explicit excerpt
+	ExcerptHtml     []byte // original HTML code of the explicit excerpt, only set while the doc is converted
 	Type            DocType
 	Status          DocStatus
 	PublishedDate   time.Time
 	CommentsEnabled bool
+	Comments        []*Comment
+	Categories      []*Category
+	Tags            []*Tag
+}
+
+type Comment struct {
+	Id          int
+	Parent      int // Id of the comment this replies to, 0 if none
+	Type        CommentType
+	Author      string
+	AuthorUrl   string
+	AuthorEmail string // only set with Options.CommentPrivate
+	AuthorIp    string // only set with Options.CommentPrivate
+	Date        time.Time
+	Content     []byte // output markdown
+	ContentHtml []byte // original HTML code
 }
 
 type Attachment struct {
-	Parent   *Doc
+	Parent   *Doc   // nil for media not attached to a doc
+	PostId   int    // Wordpress post id of the attachment
 	Url      string // Url on the Wordpress site
-	Filename string // Local media file name
+	Alt      string // alt text for images
+	Filename string // Local media file name, relative to the media directory
 }
 
 var docType = map[string]DocType{
@@ -77,67 +138,424 @@ func buildDocFor(item *wxr.Item) *Doc {
 		return nil
 	}
 
-	if item.PostParent != 0 {
-		log.Fatalf("%q (%d): Docs with parents not yet supported.\n", item.Title, item.PostId)
-	}
-
 	name := item.PostName
 	if name == "" {
 		name = generatePostId(item.Title)
 	}
 
+	// Wordpress keeps redirecting URLs with old slugs after a post is
+	// renamed.
+	var aliases []string
+	for _, meta := range item.PostMeta {
+		if meta.Key == "_wp_old_slug" && meta.Value != "" && item.PostName != "" {
+			if alias := replaceSlug(item.Link, item.PostName, meta.Value); alias != "" {
+				aliases = append(aliases, alias)
+			}
+		}
+	}
+
 	return &Doc{
 		Id:              name,
 		Title:           item.Title,
-		ContentHtml:     item.Content,
+		Link:            item.Link,
+		Aliases:         aliases,
 		Type:            typ,
 		Status:          parseDocStatus(item.Status),
-		PublishedDate:   parseWpTime(item.PostDateGmt),
+		PublishedDate:   publishedDate(item),
 		CommentsEnabled: parseCommentsEnabled(item.CommentStatus),
 	}
 }
 
+// Returns the publication date of an item. Drafts don't have a GMT date
+// yet, so fall back to the local one; it's off by the blog's time zone, but
+// better than nothing.
+func publishedDate(item *wxr.Item) time.Time {
+	date := parseWpTime(item.PostDateGmt)
+	if date.IsZero() && item.PostDate != "" {
+		date = parseWpTime(item.PostDate)
+	}
+	return date
+}
+
+// Replaces the slug in a permalink, or returns "" if the permalink doesn't
+// contain it as a path component.
+func replaceSlug(link, slug, newSlug string) string {
+	parsed, err := url.Parse(link)
+	if err != nil {
+		return ""
+	}
+	components := strings.Split(parsed.Path, "/")
+	for i := len(components) - 1; i >= 0; i-- {
+		if components[i] == slug {
+			components[i] = newSlug
+			parsed.Path = strings.Join(components, "/")
+			return parsed.String()
+		}
+	}
+	return ""
+}
+
+// Returns the path of a doc, made up of its id and those of its
+// ancestors, e.g. "about/team". Backends use it to place output files.
+func (d *Doc) Path() string {
+	if d.Parent == nil {
+		return d.Id
+	}
+	return d.Parent.Path() + "/" + d.Id
+}
+
+// Checks whether making "parent" the parent of d would create a cycle.
+func (d *Doc) isAncestorOf(parent *Doc) bool {
+	for p := parent; p != nil; p = p.Parent {
+		if p == d {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the slugs of a category and its ancestors, separated by slashes,
+// e.g. "code/graphics".
+func (c *Category) Path() string {
+	if c.Parent == nil {
+		return c.Slug
+	}
+	return c.Parent.Path() + "/" + c.Slug
+}
+
+func (c *Category) isAncestorOf(parent *Category) bool {
+	for p := parent; p != nil; p = p.Parent {
+		if p == c {
+			return true
+		}
+	}
+	return false
+}
+
 type urlRewriter struct {
-	docsByUrl    map[string]*Doc
-	attsByUrl    map[string]*Attachment
+	opts         *Options
+	blog         *Blog
+	backend      Backend
+	selfHosts    map[string]bool   // normalized host names of the blog
+	docsByUrl    map[string]*Doc   // by urlKey
+	pageAnchors  map[string]string // by urlKey, for pages of merged multi-page docs
+	docsByWpId   map[int]*Doc
+	attsByUrl    map[string]*Attachment // by urlKey
+	attsByWpId   map[int]*Attachment
 	filenameUsed map[string]bool
+	pageDocs     map[*Doc][]*Doc // later pages of split multi-page docs
+
+	doc      *Doc            // doc being converted
+	reported map[string]bool // unresolved links already reported for doc
+}
+
+// Wordpress generates resized versions of uploaded images, named by
+// appending "-<width>x<height>" to the original file name.
+var resizedImageRe = regexp.MustCompile(`^(.*)-\d+x\d+(\.[A-Za-z0-9]+)$`)
+
+// Since Wordpress 5.3, large uploads are scaled down to "<name>-scaled.jpg",
+// but their resized versions are still named after the original.
+var scaledImageRe = regexp.MustCompile(`^(.*)-scaled(\.[A-Za-z0-9]+)$`)
+
+// Lower-cases a host name and strips "www." and default ports.
+func normalizeHost(host string) string {
+	host = strings.ToLower(host)
+	host = strings.TrimSuffix(host, ":80")
+	host = strings.TrimSuffix(host, ":443")
+	return strings.TrimPrefix(host, "www.")
+}
+
+// Registers the blog's own host names, e.g. from the channel link and
+// the base blog URL.
+func (u *urlRewriter) addSelfUrl(rawurl string) {
+	if parsed, err := url.Parse(rawurl); err == nil && parsed.Host != "" {
+		u.selfHosts[normalizeHost(parsed.Host)] = true
+	}
 }
 
-func (u *urlRewriter) UrlRewrite(target string) string {
-	if parsed, err := url.Parse(target); err == nil {
-		canonical := url.URL{
-			Scheme: parsed.Scheme,
-			Host:   parsed.Host,
-			Path:   parsed.Path,
+// Query parameters that identify a doc or page on the blog. They're part of
+// the URL key; all other parameters are ignored.
+var idQueryParams = []string{"p", "page_id", "attachment_id", "page"}
+
+// Returns the key URLs are looked up by. The scheme, "www." prefixes,
+// default ports and trailing slashes don't matter, and all of the blog's
+// own host names are equivalent. Relative URLs are assumed to be on the
+// blog. The blog's home page has the empty key.
+func (u *urlRewriter) urlKey(parsed *url.URL) string {
+	host := normalizeHost(parsed.Host)
+	if u.selfHosts[host] {
+		host = ""
+	}
+	key := host + strings.TrimSuffix(parsed.Path, "/")
+
+	query := parsed.Query()
+	ids := url.Values{}
+	for _, param := range idQueryParams {
+		if val := query.Get(param); val != "" {
+			ids.Set(param, val)
 		}
-		canonicalUrl := canonical.String()
-		// try to look up as a doc
-		tgtDoc := u.docsByUrl[canonicalUrl]
-		if tgtDoc == nil && !strings.HasSuffix(canonicalUrl, "/") {
-			tgtDoc = u.docsByUrl[canonicalUrl+"/"]
+	}
+	if len(ids) != 0 {
+		key += "?" + ids.Encode()
+	}
+	return key
+}
+
+func (u *urlRewriter) addDocUrl(rawurl string, doc *Doc) {
+	if parsed, err := url.Parse(rawurl); err == nil && rawurl != "" {
+		if key := u.urlKey(parsed); key != "" {
+			u.docsByUrl[key] = doc
 		}
-		if tgtDoc != nil {
-			dest := "*" + tgtDoc.Id
-			if parsed.Fragment != "" {
-				dest += "#" + parsed.Fragment
+	}
+}
+
+func (u *urlRewriter) addAttachmentUrl(rawurl string, att *Attachment) {
+	if parsed, err := url.Parse(rawurl); err == nil && rawurl != "" {
+		if key := u.urlKey(parsed); key != "" {
+			u.attsByUrl[key] = att
+			if m := scaledImageRe.FindStringSubmatch(key); m != nil && u.attsByUrl[m[1]+m[2]] == nil {
+				u.attsByUrl[m[1]+m[2]] = att
 			}
-			//fmt.Printf("  -> %s\n", tgtDoc.Title)
-			return dest
 		}
-		// try to look up as attachment
-		tgtAtt := u.attsByUrl[canonicalUrl]
-		if tgtAtt != nil {
-			u.useAttachment(tgtAtt)
-			return mediaPath + "/" + tgtAtt.Filename
+	}
+}
+
+func (u *urlRewriter) isSelf(parsed *url.URL) bool {
+	return parsed.Host == "" || u.selfHosts[normalizeHost(parsed.Host)]
+}
+
+// A link to the blog itself that couldn't be resolved to a doc or
+// attachment.
+type UnresolvedLink struct {
+	Doc    *Doc
+	Url    string
+	Text   string // link text or image alt text
+	Reason string
+}
+
+func (u *urlRewriter) UrlRewrite(target, text string) string {
+	parsed, err := url.Parse(target)
+	if err != nil {
+		u.reportUnresolved(target, text, "can't parse URL: "+err.Error())
+		return target
+	}
+
+	// in-page anchors stay as they are
+	if parsed.Host == "" && parsed.Path == "" && parsed.RawQuery == "" {
+		return target
+	}
+
+	// try to look up as a doc
+	if tgtDoc := u.lookupDoc(parsed); tgtDoc != nil {
+		dest := u.backend.DocLink(tgtDoc)
+		fragment := parsed.Fragment
+		if fragment == "" {
+			fragment = u.pageAnchors[u.urlKey(parsed)]
+		}
+		if fragment != "" {
+			dest += "#" + fragment
 		}
+		//fmt.Printf("  -> %s\n", tgtDoc.Title)
+		return dest
+	}
+	// try to look up as attachment
+	if tgtAtt := u.lookupAttachment(parsed); tgtAtt != nil {
+		u.useAttachment(tgtAtt)
+		return u.backend.MediaLink(tgtAtt.Filename)
 	}
-	// debug only!
-	if strings.HasPrefix(target, "http://fgiesen.") {
-		fmt.Printf("  unresolved self-link %q\n", target)
+
+	if reason := u.unresolvedReason(parsed); reason != "" {
+		u.reportUnresolved(target, text, reason)
 	}
 	return target
 }
 
+// Explains why a link that couldn't be resolved should have been, or
+// returns "" for links that don't point at the blog.
+func (u *urlRewriter) unresolvedReason(parsed *url.URL) string {
+	if id, ok := u.shortlinkId(parsed); ok {
+		return fmt.Sprintf("shortlink to unknown post %d", id)
+	}
+	if parsed.Host == "" && !strings.HasPrefix(parsed.Path, "/") {
+		return "" // relative link, probably not to the old site
+	}
+	if !u.isSelf(parsed) {
+		return ""
+	}
+
+	query := parsed.Query()
+	for _, key := range []string{"p", "page_id", "attachment_id"} {
+		if id, err := strconv.Atoi(query.Get(key)); err == nil {
+			return fmt.Sprintf("unknown %s %d", key, id)
+		}
+	}
+	if strings.Contains(parsed.Path, uploadsPrefix) {
+		if resizedImageRe.MatchString(parsed.Path) {
+			return "resized version of unknown attachment"
+		}
+		return "unknown attachment"
+	}
+	return "no post or page with this URL"
+}
+
+func (u *urlRewriter) reportUnresolved(target, text, reason string) {
+	key := target + "\x00" + text
+	if u.reported[key] {
+		return
+	}
+	u.reported[key] = true
+	u.blog.Unresolved = append(u.blog.Unresolved, &UnresolvedLink{
+		Doc:    u.doc,
+		Url:    target,
+		Text:   text,
+		Reason: reason,
+	})
+}
+
+func (u *urlRewriter) lookupDoc(parsed *url.URL) *Doc {
+	key := u.urlKey(parsed)
+	if key == "" {
+		return nil // the home page isn't a doc
+	}
+
+	// "?p=123" and "?page_id=123" links work regardless of the
+	// permalink settings. The exact URL only matters for pages of split
+	// multi-page docs, "?p=123&page=2".
+	if u.isSelf(parsed) {
+		query := parsed.Query()
+		for _, param := range []string{"p", "page_id"} {
+			if id, err := strconv.Atoi(query.Get(param)); err == nil {
+				if doc := u.docsByUrl[key]; doc != nil {
+					return doc
+				}
+				return u.docsByWpId[id]
+			}
+		}
+	}
+
+	if doc := u.docsByUrl[key]; doc != nil {
+		return doc
+	}
+
+	if id, ok := u.shortlinkId(parsed); ok {
+		return u.docsByWpId[id]
+	}
+
+	return nil
+}
+
+// wp.me shortlinks are "wp.me/p<blog>-<post>" for posts and
+// "wp.me/P<blog>-<post>" for pages, with base-62 ids. Returns the post id
+// if parsed is a shortlink to this blog.
+func (u *urlRewriter) shortlinkId(parsed *url.URL) (int, bool) {
+	if normalizeHost(parsed.Host) != "wp.me" {
+		return 0, false
+	}
+	code := strings.TrimPrefix(parsed.Path, "/")
+	dash := strings.LastIndex(code, "-")
+	if dash <= 1 || (code[0] != 'p' && code[0] != 'P') {
+		return 0, false
+	}
+	if blogId := code[1:dash]; u.opts.ShortlinkBlog != "" && u.opts.ShortlinkBlog != blogId {
+		return 0, false
+	}
+	return parseBase62(code[dash+1:])
+}
+
+func (u *urlRewriter) lookupAttachment(parsed *url.URL) *Attachment {
+	key := u.urlKey(parsed)
+	if key == "" {
+		return nil
+	}
+
+	if u.isSelf(parsed) {
+		if id, err := strconv.Atoi(parsed.Query().Get("attachment_id")); err == nil {
+			return u.attsByWpId[id]
+		}
+	}
+
+	if att := u.attsByUrl[key]; att != nil {
+		return att
+	}
+
+	// Resized images don't show up in the export themselves. Depending on
+	// Options.Variants, they map to either the full-size image or an
+	// attachment of their own that shares the original's metadata.
+	m := resizedImageRe.FindStringSubmatch(key)
+	if m == nil {
+		return nil
+	}
+	orig := u.attsByUrl[m[1]+m[2]]
+	if orig == nil || u.opts.Variants == "full" {
+		return orig
+	}
+
+	canonical := url.URL{
+		Scheme: parsed.Scheme,
+		Host:   parsed.Host,
+		Path:   parsed.Path,
+	}
+	variant := &Attachment{
+		Parent: orig.Parent,
+		PostId: orig.PostId,
+		Url:    canonical.String(),
+		Alt:    orig.Alt,
+	}
+	if strings.HasPrefix(variant.Url, "/") {
+		// relative link; fetch it from wherever the original lives
+		if origUrl, err := url.Parse(orig.Url); err == nil {
+			variant.Url = origUrl.ResolveReference(&canonical).String()
+		}
+	}
+	u.attsByUrl[key] = variant
+	u.blog.Attachments = append(u.blog.Attachments, variant)
+	return variant
+}
+
+// Returns the attachments shown by a gallery: the ones with the given ids,
+// or if there are none, the ones attached to the doc being converted, in
+// upload order and minus the excluded ids.
+func (u *urlRewriter) GalleryAttachments(ids, exclude []int) []*Attachment {
+	var atts []*Attachment
+	if len(ids) != 0 {
+		for _, id := range ids {
+			if att := u.attsByWpId[id]; att != nil {
+				atts = append(atts, att)
+			} else {
+				u.reportUnresolved(fmt.Sprintf("[gallery] id %d", id), "", "unknown attachment")
+			}
+		}
+		return atts
+	}
+
+	excluded := make(map[int]bool)
+	for _, id := range exclude {
+		excluded[id] = true
+	}
+	for _, att := range u.blog.Attachments {
+		// skip resized variants, they share the original's id
+		if att.Parent == u.doc && u.attsByWpId[att.PostId] == att && !excluded[att.PostId] {
+			atts = append(atts, att)
+		}
+	}
+	sort.Slice(atts, func(i, j int) bool { return atts[i].PostId < atts[j].PostId })
+	return atts
+}
+
+const base62Digits = "0123456789abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"
+
+func parseBase62(s string) (int, bool) {
+	val := 0
+	for i := 0; i < len(s); i++ {
+		digit := strings.IndexByte(base62Digits, s[i])
+		if digit < 0 {
+			return 0, false
+		}
+		val = val*62 + digit
+	}
+	return val, s != ""
+}
+
 func (u *urlRewriter) useAttachment(a *Attachment) {
 	// if we've already assigned a file name, we're good!
 	if a.Filename != "" {
@@ -149,17 +567,32 @@ func (u *urlRewriter) useAttachment(a *Attachment) {
 		log.Fatalf("Can't parse attachment URL %q", a.Url)
 	}
 
+	dir, basename := "", path.Base(parsed.Path)
+	if u.opts.MediaLayout == "uploads" {
+		if dir = path.Dir(uploadsPath(parsed.Path)); dir == "." {
+			dir = ""
+		} else {
+			dir += "/"
+		}
+	}
+
 	// try original name
-	basename := path.Base(parsed.Path)
-	if u.tryAttachmentFilename(a, basename) {
+	if u.tryAttachmentFilename(a, dir+basename) {
 		return
 	}
 
-	// if that didn't work, just keep trying random 32-bit prefixes to
-	// make it unique
-	for {
-		name := fmt.Sprintf("%08x_%s", rand.Uint32(), basename)
-		if u.tryAttachmentFilename(a, name) {
+	// if that didn't work, disambiguate. Names are assigned in export
+	// order, and the prefixes only depend on the attachment, so this is
+	// stable across runs.
+	if a.PostId != 0 && u.tryAttachmentFilename(a, fmt.Sprintf("%s%d_%s", dir, a.PostId, basename)) {
+		return
+	}
+	hash := crc32.ChecksumIEEE([]byte(a.Url))
+	if u.tryAttachmentFilename(a, fmt.Sprintf("%s%08x_%s", dir, hash, basename)) {
+		return
+	}
+	for n := 2; ; n++ {
+		if u.tryAttachmentFilename(a, fmt.Sprintf("%s%08x_%d_%s", dir, hash, n, basename)) {
 			break
 		}
 	}
@@ -175,69 +608,499 @@ func (u *urlRewriter) tryAttachmentFilename(a *Attachment, filename string) bool
 	return false
 }
 
-func convert(channel *wxr.Channel) *Blog {
-	if len(channel.Authors) > 1 {
-		log.Fatalf("Only one author supported right now.\n")
+// Returns the author with the given login. Logins that aren't in the
+// author table get a placeholder entry.
+func (b *Blog) authorFor(login string) *Author {
+	if login == "" {
+		return nil
+	}
+	author := b.Authors[login]
+	if author == nil {
+		fmt.Printf("unknown author %q\n", login)
+		author = &Author{Login: login, Name: login}
+		b.Authors[login] = author
+	}
+	return author
+}
+
+// Returns the category with the given slug, adding it if necessary.
+func (b *Blog) categoryFor(slug, name string) *Category {
+	cat := b.Categories[slug]
+	if cat == nil {
+		cat = &Category{Slug: slug, Name: name}
+		b.Categories[slug] = cat
 	}
-	author := channel.Authors[0]
+	return cat
+}
 
-	blog := &Blog{
-		Author: Author{
-			Name:  author.DisplayName,
+// Returns the tag with the given slug, adding it if necessary.
+func (b *Blog) tagFor(slug, name string) *Tag {
+	tag := b.Tags[slug]
+	if tag == nil {
+		tag = &Tag{Slug: slug, Name: name}
+		b.Tags[slug] = tag
+	}
+	return tag
+}
+
+// Sets up the blog-wide category hierarchy and tags.
+func (b *Blog) buildTaxonomy(channel *wxr.Channel) {
+	for _, c := range channel.Categories {
+		b.categoryFor(c.NiceName, c.Name)
+	}
+	for _, c := range channel.Categories {
+		if c.Parent == "" {
+			continue
+		}
+		cat, parent := b.Categories[c.NiceName], b.Categories[c.Parent]
+		if parent == nil {
+			fmt.Printf("category %q refers to unknown parent %q\n", c.NiceName, c.Parent)
+			continue
+		}
+		if !cat.isAncestorOf(parent) {
+			cat.Parent = parent
+		}
+	}
+
+	for _, t := range channel.Tags {
+		b.tagFor(t.Slug, t.Name)
+	}
+}
+
+// Attaches an item's categories and tags to the corresponding doc.
+func (b *Blog) classify(doc *Doc, item *wxr.Item) {
+	for _, c := range item.Categories {
+		switch c.Domain {
+		case "category":
+			doc.Categories = append(doc.Categories, b.categoryFor(c.NiceName, c.Name))
+		case "post_tag":
+			doc.Tags = append(doc.Tags, b.tagFor(c.NiceName, c.Name))
+		}
+	}
+}
+
+// Sets up the author table.
+func (b *Blog) buildAuthors(channel *wxr.Channel) {
+	for _, author := range channel.Authors {
+		name := author.DisplayName
+		if name == "" {
+			name = author.Login
+		}
+		b.Authors[author.Login] = &Author{
+			Login: author.Login,
+			Name:  name,
 			Email: author.Email,
-		},
+		}
+	}
+}
+
+// Converts WXR exports. Multiple exports are parts of the same blog, e.g.
+// from Wordpress splitting up a large export, so they're all indexed before
+// anything is converted: links and attachment file names work across them,
+// and doc paths are unique among all of them.
+//
+// The exports are read twice: the first pass only collects what's needed to
+// resolve links, the second one converts the docs and writes them to dest
+// one at a time, so only the content of the doc at hand is kept in memory.
+func convert(filenames []string, dest string, backend Backend, opts *Options) (*Blog, error) {
+	blog := &Blog{
+		Authors:    make(map[string]*Author),
+		Categories: make(map[string]*Category),
+		Tags:       make(map[string]*Tag),
 	}
 
-	// First pass: handle regular docs
-	var rewriter urlRewriter
+	// First pass: read the exports, keeping docs and attachments.
+	rewriter := urlRewriter{opts: opts, blog: blog, backend: backend}
 
-	idsTaken := make(map[string]*Doc)
-	docsByWpId := make(map[int]*Doc)
+	fileDocs := make([][]*Doc, len(filenames)) // docs of each file, in export order
+	parentIds := make(map[*Doc]int)
+	pageCounts := make(map[*Doc]int)
+	attParentIds := make(map[*Attachment]int)
+	rewriter.selfHosts = make(map[string]bool)
 	rewriter.docsByUrl = make(map[string]*Doc)
+	rewriter.pageAnchors = make(map[string]string)
+	rewriter.docsByWpId = make(map[int]*Doc)
 	rewriter.attsByUrl = make(map[string]*Attachment)
+	rewriter.attsByWpId = make(map[int]*Attachment)
 	rewriter.filenameUsed = make(map[string]bool)
-	for _, item := range channel.Items {
-		if doc := buildDocFor(item); doc != nil {
-			// NOTE: We can resolve ID collisions by just reassigning them to *make*
-			// them unique, however right now, just don't handle that case.
-			if other := idsTaken[doc.Id]; other != nil {
-				log.Fatalf("Post name %q occurs twice (posts %q and %q).\n", doc.Id, other.Title, doc.Title)
+	rewriter.pageDocs = make(map[*Doc][]*Doc)
+	for i, filename := range filenames {
+		sawItems := false
+		err := readItems(filename, func(dec *wxr.Decoder, item *wxr.Item) error {
+			// Exports list authors, categories and the blog URLs before
+			// the items.
+			if !sawItems {
+				blog.buildAuthors(&dec.Channel)
+				blog.buildTaxonomy(&dec.Channel)
+				rewriter.addSelfUrl(dec.Channel.Link)
+				rewriter.addSelfUrl(dec.Channel.BaseBlogUrl)
+				sawItems = true
+			}
+
+			if doc := buildDocFor(item); doc != nil {
+				doc.Author = blog.authorFor(item.Creator)
+				blog.classify(doc, item)
+				rewriter.docsByWpId[item.PostId] = doc
+				if item.PostParent != 0 {
+					parentIds[doc] = item.PostParent
+				}
+				rewriter.addDocUrl(item.Link, doc)
+				for _, alias := range doc.Aliases {
+					rewriter.addDocUrl(alias, doc)
+				}
+				pageCounts[doc] = len(splitPages(item.Content))
+				blog.Docs = append(blog.Docs, doc)
+				fileDocs[i] = append(fileDocs[i], doc)
+			} else if item.PostType == "attachment" {
+				att := &Attachment{
+					PostId: item.PostId,
+					Url:    item.AttachmentUrl,
+				}
+				for _, meta := range item.PostMeta {
+					if meta.Key == "_wp_attachment_image_alt" {
+						att.Alt = meta.Value
+					}
+				}
+				attParentIds[att] = item.PostParent
+				rewriter.attsByWpId[item.PostId] = att
+				rewriter.addAttachmentUrl(att.Url, att)
+				// links to the attachment page go to the file itself
+				rewriter.addAttachmentUrl(item.Link, att)
+				blog.Attachments = append(blog.Attachments, att)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Link up hierarchical docs. Parents may occur after their children in
+	// the export, so this needs to happen after all docs are known.
+	for _, doc := range blog.Docs {
+		parentId, ok := parentIds[doc]
+		if !ok {
+			continue
+		}
+		parent := rewriter.docsByWpId[parentId]
+		if parent == nil {
+			fmt.Printf("%q refers to unknown parent %d, treating it as top-level.\n", doc.Title, parentId)
+		} else if doc.isAncestorOf(parent) {
+			fmt.Printf("%q has a cyclic parent chain, treating it as top-level.\n", doc.Title)
+		} else {
+			doc.Parent = parent
+		}
+	}
+
+	uniquifyDocPaths(blog.Docs)
+	blog.Docs = rewriter.handlePages(blog.Docs, pageCounts)
+
+	// Same for attachments. Media that was uploaded without a post has
+	// parent 0.
+	for _, att := range blog.Attachments {
+		if parentId := attParentIds[att]; parentId != 0 {
+			att.Parent = rewriter.docsByWpId[parentId]
+			if att.Parent == nil {
+				fmt.Printf("Attachment %q refers to unknown parent %d.\n", att.Url, parentId)
 			}
-			idsTaken[doc.Id] = doc
-			docsByWpId[item.PostId] = doc
-			rewriter.docsByUrl[item.Link] = doc
-			blog.Docs = append(blog.Docs, doc)
 		}
 	}
 
-	// Second pass: handle attachments
-	for _, item := range channel.Items {
-		if item.PostType == "attachment" {
-			parentDoc, ok := docsByWpId[item.PostParent]
-			if !ok {
-				log.Fatalf("Attachment %d refers to unknown parent %d.\n", item.PostId, item.PostParent)
+	// Second pass: convert and write the docs. Items come in the same order
+	// as in the first pass.
+	for i, filename := range filenames {
+		docs := fileDocs[i]
+		err := readItems(filename, func(dec *wxr.Decoder, item *wxr.Item) error {
+			if _, ok := docType[item.PostType]; !ok {
+				return nil
+			}
+			doc := docs[0]
+			docs = docs[1:]
+
+			doc.ExcerptHtml = bytes.TrimSpace(item.Excerpt)
+			if opts.Comments {
+				doc.Comments = buildComments(item.Comments, opts)
 			}
-			att := &Attachment{
-				Parent: parentDoc,
-				Url:    item.AttachmentUrl,
+			for _, d := range rewriter.setPageContent(doc, item.Content) {
+				if opts.Statuses[d.Status] {
+					rewriter.convertDoc(d)
+					if err := backend.WriteDoc(blog, d, dest); err != nil {
+						return err
+					}
+				}
+				// only the metadata is needed from here on
+				d.ContentHtml, d.ExcerptHtml, d.Comments = nil, nil, nil
+				d.Content, d.Excerpt = nil, nil
 			}
-			rewriter.attsByUrl[att.Url] = att
-			blog.Attachments = append(blog.Attachments, att)
+			return nil
+		})
+		if err != nil {
+			return nil, err
 		}
 	}
 
-	// Generate markdown for docs
-	for _, doc := range blog.Docs {
-		fmt.Printf("doc: %s\n", doc.Title)
+	return blog, nil
+}
+
+// Generates the markdown for a doc and its comments.
+func (u *urlRewriter) convertDoc(doc *Doc) {
+	fmt.Printf("doc: %s\n", doc.Title)
+	u.doc = doc
+	u.reported = make(map[string]bool)
 
-		var err error
-		doc.Content, err = ConvertHtmlToMarkdown(doc.ContentHtml, &rewriter)
+	var err error
+	doc.Content, err = ConvertHtmlToMarkdown(doc.ContentHtml, u)
+	if err != nil {
+		log.Fatalf("%q: Error converting contents to markdown: %s\n", doc.Title, err.Error())
+	}
+	doc.ContentHtml = nil // no need to keep both around
+
+	if len(doc.ExcerptHtml) != 0 {
+		doc.Excerpt, err = ConvertHtmlToMarkdown(doc.ExcerptHtml, u)
 		if err != nil {
-			log.Fatalf("%q: Error converting contents to markdown: %s\n", doc.Title, err.Error())
+			log.Fatalf("%q: Error converting excerpt to markdown: %s\n", doc.Title, err.Error())
+		}
+		doc.ExcerptHtml = nil
+	} else {
+		doc.Excerpt = moreExcerpt(doc.Content)
+	}
+
+	for _, com := range doc.Comments {
+		// Comments are written by arbitrary people, so don't give up
+		// on the whole blog if one of them has broken markup.
+		com.Content, err = ConvertHtmlToMarkdown(com.ContentHtml, u)
+		if err != nil {
+			fmt.Printf("%q: comment %d: %s, keeping it as HTML.\n", doc.Title, com.Id, err.Error())
+			com.Content = com.ContentHtml
+		}
+	}
+}
+
+// Returns the Markdown before the "more" marker, or nil if there is none.
+func moreExcerpt(content []byte) []byte {
+	if i := bytes.Index(content, []byte(moreMarker)); i != -1 {
+		return bytes.TrimSpace(content[:i])
+	}
+	return nil
+}
+
+// Wordpress splits docs into several pages at these markers. The pages live
+// at "<permalink>/2/" etc.
+var nextpageMarker = []byte("<!--nextpage-->")
+
+// Wordpress drops the line breaks around the markers and the block
+// editor's delimiters before splitting.
+var pageMarkerCleanup = strings.NewReplacer(
+	"\n<!--nextpage-->\n", "<!--nextpage-->",
+	"\n<!--nextpage-->", "<!--nextpage-->",
+	"<!--nextpage-->\n", "<!--nextpage-->",
+	"<!-- wp:nextpage -->", "",
+	"<!-- /wp:nextpage -->", "",
+)
+
+// Splits doc content into its pages.
+func splitPages(content []byte) [][]byte {
+	// Wordpress ignores a marker at the very start
+	content = []byte(pageMarkerCleanup.Replace(string(content)))
+	content = bytes.TrimPrefix(content, nextpageMarker)
+	return bytes.Split(content, nextpageMarker)
+}
+
+// Deals with multi-page docs, given the number of pages of every doc. With
+// opts.Pages == "merge", the pages stay in one doc, and links to them go to
+// the anchors ConvertHtmlToMarkdown puts at the page breaks. With "split",
+// every page after the first becomes a child doc "page-<n>". Returns the new
+// list of docs.
+func (u *urlRewriter) handlePages(docs []*Doc, pageCounts map[*Doc]int) []*Doc {
+	var out []*Doc
+	for _, doc := range docs {
+		out = append(out, doc)
+
+		for n := 2; n <= pageCounts[doc]; n++ {
+			link := pageLink(doc.Link, n)
+			if u.opts.Pages == "merge" {
+				if parsed, err := url.Parse(link); err == nil && link != "" {
+					key := u.urlKey(parsed)
+					u.docsByUrl[key] = doc
+					u.pageAnchors[key] = fmt.Sprintf("page-%d", n)
+				}
+				continue
+			}
+
+			part := &Doc{
+				Id:            fmt.Sprintf("page-%d", n),
+				Title:         fmt.Sprintf("%s (page %d)", doc.Title, n),
+				Link:          link,
+				Author:        doc.Author,
+				Parent:        doc,
+				Type:          doc.Type,
+				Status:        doc.Status,
+				PublishedDate: doc.PublishedDate,
+			}
+			u.addDocUrl(part.Link, part)
+			u.pageDocs[doc] = append(u.pageDocs[doc], part)
+			out = append(out, part)
 		}
 	}
+	return out
+}
+
+// Sets the HTML content of a doc. If it was split into pages by
+// handlePages, every page gets its part of the content, with links to the
+// pages before and after it. Returns the doc along with its pages.
+func (u *urlRewriter) setPageContent(doc *Doc, content []byte) []*Doc {
+	pages := splitPages(content)
+	parts := u.pageDocs[doc]
+	if len(parts) == 0 {
+		doc.ContentHtml = bytes.Join(pages, nextpageMarker)
+		return []*Doc{doc}
+	}
 
-	return blog
+	docs := append([]*Doc{doc}, parts...)
+	for i, d := range docs {
+		page := pages[i]
+
+		// navigation, resolved like any other link
+		var nav bytes.Buffer
+		if prev := pageLink(doc.Link, i); i > 0 && prev != "" {
+			fmt.Fprintf(&nav, `<a href="%s">Previous page</a>`, html.EscapeString(prev))
+		}
+		if next := pageLink(doc.Link, i+2); i+1 < len(docs) && next != "" {
+			if nav.Len() != 0 {
+				nav.WriteString(" &middot; ")
+			}
+			fmt.Fprintf(&nav, `<a href="%s">Next page</a>`, html.EscapeString(next))
+		}
+		if nav.Len() != 0 {
+			// full slice expression, so pages don't overwrite each other
+			page = append(page[:len(page):len(page)], "\n\n<p>"+nav.String()+"</p>"...)
+		}
+		d.ContentHtml = page
+	}
+	return docs
+}
+
+// Returns the URL of page n of a multi-page doc, or "" if there is none.
+func pageLink(link string, n int) string {
+	parsed, err := url.Parse(link)
+	if err != nil || link == "" {
+		return ""
+	}
+	if n == 1 {
+		return link
+	}
+	if parsed.RawQuery != "" {
+		// "?p=123&page=2" for blogs without pretty permalinks
+		query := parsed.Query()
+		query.Set("page", strconv.Itoa(n))
+		parsed.RawQuery = query.Encode()
+	} else {
+		parsed.Path = strings.TrimSuffix(parsed.Path, "/") + "/" + strconv.Itoa(n) + "/"
+	}
+	return parsed.String()
+}
+
+// Makes doc paths unique by appending "-2", "-3" etc. to the ids of docs
+// whose path is already taken. Docs are handled in export order with parents
+// before their children, so the renaming is deterministic. Since all links
+// are resolved to docs, not names, they pick up the new names automatically.
+func uniquifyDocPaths(docs []*Doc) {
+	var levels [][]*Doc
+	for _, doc := range docs {
+		depth := 0
+		for p := doc.Parent; p != nil; p = p.Parent {
+			depth++
+		}
+		for len(levels) <= depth {
+			levels = append(levels, nil)
+		}
+		levels[depth] = append(levels[depth], doc)
+	}
+
+	for _, level := range levels {
+		// don't steal paths from docs that haven't been visited yet
+		original := make(map[string]bool)
+		for _, doc := range level {
+			original[doc.Path()] = true
+		}
+
+		claimed := make(map[string]*Doc)
+		for _, doc := range level {
+			oldPath := doc.Path()
+			other := claimed[oldPath]
+			if other == nil {
+				claimed[oldPath] = doc
+				continue
+			}
+
+			baseId := doc.Id
+			for n := 2; ; n++ {
+				doc.Id = fmt.Sprintf("%s-%d", baseId, n)
+				newPath := doc.Path()
+				if !original[newPath] && claimed[newPath] == nil {
+					claimed[newPath] = doc
+					fmt.Printf("renamed %q to %q (%q clashes with %q)\n", oldPath, newPath, doc.Title, other.Title)
+					break
+				}
+			}
+		}
+	}
+}
+
+// Builds the list of approved comments on a doc, sorted by date. Replies to
+// comments that didn't make the cut are attached to the closest ancestor
+// that did.
+func buildComments(comments []*wxr.Comment, opts *Options) []*Comment {
+	byId := make(map[int]*wxr.Comment)
+	for _, com := range comments {
+		byId[com.Id] = com
+	}
+
+	keep := func(com *wxr.Comment) bool {
+		return com.Approved == "1" && (opts.Pingbacks || parseCommentType(com.Type) == CommentRegular)
+	}
+
+	var result []*Comment
+	for _, com := range comments {
+		if !keep(com) {
+			continue
+		}
+
+		// find closest kept ancestor. (the depth limit guards against cycles)
+		parent := com.Parent
+		for depth := 0; parent != 0 && depth < len(comments); depth++ {
+			if p := byId[parent]; p == nil {
+				parent = 0
+			} else if keep(p) {
+				break
+			} else {
+				parent = p.Parent
+			}
+		}
+
+		c := &Comment{
+			Id:          com.Id,
+			Parent:      parent,
+			Type:        parseCommentType(com.Type),
+			Author:      com.Author,
+			AuthorUrl:   com.AuthorUrl,
+			Date:        parseWpTime(com.DateGmt),
+			ContentHtml: []byte(com.Content),
+		}
+		if opts.CommentPrivate {
+			c.AuthorEmail = com.AuthorEmail
+			c.AuthorIp = com.AuthorIp
+		}
+		result = append(result, c)
+	}
+
+	sort.SliceStable(result, func(i, j int) bool {
+		if !result[i].Date.Equal(result[j].Date) {
+			return result[i].Date.Before(result[j].Date)
+		}
+		return result[i].Id < result[j].Id
+	})
+	return result
 }
 
 func generatePostId(title string) string {
@@ -256,9 +1119,13 @@ func generatePostId(title string) string {
 	}, title)
 }
 
+// Trackbacks are close enough to pingbacks that we don't bother telling
+// them apart.
 var commentType = map[string]CommentType{
-	"":         CommentRegular,
-	"pingback": CommentPingback,
+	"":          CommentRegular,
+	"comment":   CommentRegular,
+	"pingback":  CommentPingback,
+	"trackback": CommentPingback,
 }
 
 func parseCommentType(typ string) CommentType {
@@ -274,6 +1141,16 @@ var docStatus = map[string]DocStatus{
 	"draft":   StatusDraft,
 	"pending": StatusPending,
 	"private": StatusPrivate,
+	"future":  StatusFuture,
+}
+
+func (s DocStatus) String() string {
+	for name, val := range docStatus {
+		if val == s {
+			return name
+		}
+	}
+	return fmt.Sprintf("DocStatus(%d)", int(s))
 }
 
 func parseDocStatus(status string) DocStatus {
@@ -297,6 +1174,23 @@ func parseCommentsEnabled(commentStatus string) bool {
 	return val
 }
 
+// Parses a comma-separated list of doc statuses, e.g. "publish,draft".
+func parseStatusList(list string) (map[DocStatus]bool, error) {
+	statuses := make(map[DocStatus]bool)
+	for _, name := range strings.Split(list, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		val, ok := docStatus[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown post status %q", name)
+		}
+		statuses[val] = true
+	}
+	return statuses, nil
+}
+
 func parseWpTime(val string) time.Time {
 	if val == "0000-00-00 00:00:00" {
 		return time.Time{}
@@ -309,21 +1203,25 @@ func parseWpTime(val string) time.Time {
 	return time
 }
 
-func readWxr(filename string) (*wxr.Rss, error) {
-	data, err := ioutil.ReadFile(filename)
+// Calls fn for each item in a WXR file. The file is decoded incrementally
+// so large exports don't need to be held in memory in their entirety.
+func readItems(filename string, fn func(dec *wxr.Decoder, item *wxr.Item) error) error {
+	file, err := os.Open(filename)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	r := new(wxr.Rss)
-	err = xml.Unmarshal(data, r)
-	return r, err
-}
+	defer file.Close()
 
-func printComments(comments []*wxr.Comment) {
-	for _, com := range comments {
-		typ := parseCommentType(com.Type)
-		if typ == CommentRegular {
-			//fmt.Printf("  * %d by %s\n", com.Id, com.Author)
+	dec := wxr.NewDecoder(file)
+	for {
+		item, err := dec.Next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("%s: %s", filename, err.Error())
+		}
+		if err = fn(dec, item); err != nil {
+			return err
 		}
 	}
 }
@@ -332,82 +1230,343 @@ func writePost(wr io.Writer, doc *Doc) error {
 	// write headers
 	fmt.Fprintf(wr, "-title=%s\n", doc.Title)
 	fmt.Fprintf(wr, "-time=%s\n", doc.PublishedDate.Format("2006-01-02 15:04:05"))
+	if doc.Author != nil {
+		fmt.Fprintf(wr, "-author=%s\n", doc.Author.Name)
+	}
 	if doc.Type == DocPage {
 		fmt.Fprintf(wr, "-type=page\n")
 	}
+	if doc.Status != StatusPublish {
+		fmt.Fprintf(wr, "-status=%s\n", doc.Status)
+	}
+	writeHeader(wr, "excerpt", excerptLine(doc.Excerpt))
+	if len(doc.Categories) != 0 {
+		var cats []string
+		for _, cat := range doc.Categories {
+			cats = append(cats, cat.Path())
+		}
+		fmt.Fprintf(wr, "-categories=%s\n", strings.Join(cats, ", "))
+	}
+	if len(doc.Tags) != 0 {
+		var tags []string
+		for _, tag := range doc.Tags {
+			tags = append(tags, tag.Slug)
+		}
+		fmt.Fprintf(wr, "-tags=%s\n", strings.Join(tags, ", "))
+	}
 
 	// write content
 	_, err := wr.Write(doc.Content)
 	return err
 }
 
-func process(blog *Blog, dest string) error {
-	media := filepath.Join(dest, mediaPath)
-	if err := os.MkdirAll(media, 0733); err != nil {
-		return err
+// Writes the comments on a doc. The format mirrors writePost: every comment
+// starts with a "-comment=<id>" line, followed by more header lines
+// ("-parent=<id>" for replies, "-author=", "-url=", "-email=", "-ip=",
+// "-time=" and "-type=pingback" for pingbacks and trackbacks), then an
+// empty line and the comment body in Markdown. Empty headers are omitted.
+func writeComments(wr io.Writer, comments []*Comment) error {
+	for i, com := range comments {
+		if i > 0 {
+			fmt.Fprintf(wr, "\n\n")
+		}
+
+		fmt.Fprintf(wr, "-comment=%d\n", com.Id)
+		if com.Parent != 0 {
+			fmt.Fprintf(wr, "-parent=%d\n", com.Parent)
+		}
+		writeHeader(wr, "author", com.Author)
+		writeHeader(wr, "url", com.AuthorUrl)
+		writeHeader(wr, "email", com.AuthorEmail)
+		writeHeader(wr, "ip", com.AuthorIp)
+		fmt.Fprintf(wr, "-time=%s\n", com.Date.Format("2006-01-02 15:04:05"))
+		if com.Type == CommentPingback {
+			fmt.Fprintf(wr, "-type=pingback\n")
+		}
+		fmt.Fprintf(wr, "\n")
+
+		if _, err := wr.Write(com.Content); err != nil {
+			return err
+		}
 	}
+	return nil
+}
 
-	// attachments
-	var client http.Client
-	for _, att := range blog.Attachments {
-		if att.Filename == "" {
+func writeHeader(wr io.Writer, key, value string) {
+	if value != "" {
+		// headers are single-line
+		value = strings.Join(strings.Fields(value), " ")
+		fmt.Fprintf(wr, "-%s=%s\n", key, value)
+	}
+}
+
+// Headers can't hold block structure, so this keeps only the leading
+// paragraphs of an excerpt that are a single line of text, stopping at the
+// first code block, list etc., and joins them.
+func excerptLine(excerpt []byte) string {
+	var paras []string
+	for _, para := range strings.Split(string(excerpt), "\n\n") {
+		para = strings.TrimRight(para, "\n")
+		if para == "" {
 			continue
 		}
+		if strings.Contains(para, "\n") || strings.HasPrefix(para, "    ") || strings.HasPrefix(para, "\t") {
+			break
+		}
+		paras = append(paras, para)
+	}
+	return strings.Join(paras, " ")
+}
 
-		fname := filepath.Join(media, att.Filename)
-		if file, err := os.OpenFile(fname, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644); err == nil {
-			fmt.Printf("fetching %q... ", att.Filename)
-			resp, err := client.Get(att.Url)
-			if err != nil {
-				file.Close()
-				os.Remove(fname)
-				log.Fatalf("Error fetching %q: %s", att.Url, err.Error())
-			}
-			if resp.StatusCode < 200 || resp.StatusCode > 299 {
-				file.Close()
-				os.Remove(fname)
-				log.Fatalf("HTTP error fetching %q: %s", att.Url, resp.Status)
-			}
-			written, err := io.Copy(file, resp.Body)
-			resp.Body.Close()
-			file.Close()
-			if err != nil {
-				os.Remove(fname)
-				log.Fatalf("Error fetching body of %q: %s\n", att.Url, err.Error())
-			}
-			fmt.Printf("%d bytes.\n", written)
+// Writes the unresolved link report as tab-separated values: doc path,
+// URL, link text and the reason the link couldn't be resolved.
+func writeUnresolved(wr io.Writer, links []*UnresolvedLink) error {
+	clean := func(s string) string {
+		return strings.Join(strings.Fields(s), " ")
+	}
+
+	fmt.Fprintf(wr, "doc\turl\ttext\treason\n")
+	for _, link := range links {
+		docPath := ""
+		if link.Doc != nil {
+			docPath = link.Doc.Path()
 		}
+		_, err := fmt.Fprintf(wr, "%s\t%s\t%s\t%s\n", docPath, clean(link.Url), clean(link.Text), link.Reason)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// A Backend writes converted blogs in the format of a particular static
+// site generator.
+type Backend interface {
+	// Returns the link target used in Markdown to refer to a doc.
+	DocLink(doc *Doc) string
+
+	// Returns the media directory, relative to the output directory.
+	MediaDir() string
+
+	// Returns the link target used in Markdown to refer to a media file.
+	// filename is relative to the media directory.
+	MediaLink(filename string) string
+
+	// Writes a converted doc, along with its comments, to the output
+	// directory. Docs are written as they're converted, so only doc
+	// metadata is available for the other docs of the blog, and the media
+	// files are fetched afterwards.
+	WriteDoc(blog *Blog, doc *Doc, dest string) error
+}
+
+var backends = map[string]func(opts *Options) Backend{
+	"block":  newBlockBackend,
+	"hugo":   newHugoBackend,
+	"jekyll": newJekyllBackend,
+}
+
+// Writes "Block"-style posts: "<path>.md" files with "-key=value" headers,
+// with comments next to them in "<path>.comments".
+type blockBackend struct {
+	opts *Options
+}
+
+func newBlockBackend(opts *Options) Backend {
+	return &blockBackend{opts: opts}
+}
+
+func (b *blockBackend) DocLink(doc *Doc) string {
+	return "*" + doc.Path()
+}
+
+func (b *blockBackend) MediaDir() string {
+	return b.opts.MediaPath
+}
+
+func (b *blockBackend) MediaLink(filename string) string {
+	return b.opts.MediaPath + "/" + filename
+}
+
+func (b *blockBackend) WriteDoc(blog *Blog, doc *Doc, dest string) error {
+	base := filepath.Join(dest, filepath.FromSlash(doc.Path()))
+	err := writeFile(base+".md", func(wr io.Writer) error {
+		return writePost(wr, doc)
+	})
+	if err != nil {
+		return err
+	}
+
+	if len(doc.Comments) != 0 {
+		err = writeFile(base+".comments", func(wr io.Writer) error {
+			return writeComments(wr, doc.Comments)
+		})
+	}
+	return err
+}
+
+// Creates a file, along with any missing directories, and fills it in
+// using write.
+func writeFile(fname string, write func(wr io.Writer) error) error {
+	if err := os.MkdirAll(filepath.Dir(fname), 0755); err != nil {
+		return err
+	}
+	file, err := os.Create(fname)
+	if err != nil {
+		return err
+	}
+	err = write(file)
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func process(blog *Blog, dest string, backend Backend, opts *Options) error {
+	media := filepath.Join(dest, filepath.FromSlash(backend.MediaDir()))
+	if err := os.MkdirAll(media, 0733); err != nil {
+		return err
 	}
 
-	// documents
+	// attachments; the docs are written during conversion, which also
+	// picks the file names.
+	blog.FetchErrors = fetchAttachments(blog.Attachments, media, newMediaSource(opts), opts)
+
+	skipped := make(map[DocStatus]int)
 	for _, doc := range blog.Docs {
-		if doc.Status != StatusPublish {
-			continue
+		if !opts.Statuses[doc.Status] {
+			skipped[doc.Status]++
+		}
+	}
+	for status := StatusPublish; status <= StatusFuture; status++ {
+		if skipped[status] != 0 {
+			fmt.Printf("skipped %d doc(s) with status %q; add it to -status to export them.\n", skipped[status], status)
 		}
+	}
 
-		fname := filepath.Join(dest, doc.Id+".md")
-		if file, err := os.Create(fname); err == nil {
-			err = writePost(file, doc)
-			file.Close()
-		} else {
-			return err
+	if len(blog.FetchErrors) != 0 {
+		fmt.Printf("%d attachment(s) couldn't be fetched:\n", len(blog.FetchErrors))
+		for _, err := range blog.FetchErrors {
+			fmt.Printf("  %s\n", err.Error())
 		}
 	}
 
 	return nil
 }
 
+func backendNames() []string {
+	var names []string
+	for name := range backends {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: wp2block [flags] export.xml...\n\n")
+	fmt.Fprintf(os.Stderr, "Converts Wordpress WXR exports to static blog posts. Multiple\n")
+	fmt.Fprintf(os.Stderr, "exports are taken to be parts of the same blog.\n\n")
+	fmt.Fprintf(os.Stderr, "flags:\n")
+	flag.PrintDefaults()
+}
+
 func main() {
-	r, err := readWxr("c:\\Store\\Downloads\\therygblog.wordpress.2013-07-23.xml")
-	if err != nil {
-		fmt.Printf("Error reading WXR: %s\n", err.Error())
-		return
+	var opts Options
+	var dest, format, statuses, report string
+
+	flag.StringVar(&dest, "out", "posts", "output directory")
+	flag.StringVar(&format, "format", "block", "output format: "+strings.Join(backendNames(), ", "))
+	flag.StringVar(&opts.MediaPath, "media", "wpmedia", "name of the media directory, relative to the output directory")
+	flag.StringVar(&opts.MediaLayout, "media-layout", "flat", "layout of the media directory: \"flat\", or \"uploads\" to keep Wordpress' YYYY/MM subdirectories")
+	flag.StringVar(&opts.Variants, "variants", "full", "how to handle resized images: \"full\" links to the full-size image, \"exact\" fetches the resized version")
+	flag.StringVar(&opts.ShortlinkBlog, "shortlink-blog", "", "the blog's wp.me shortlink id (e.g. \"1a2b3\" for wp.me/p1a2b3-..); if unset, all wp.me links are resolved")
+	flag.StringVar(&opts.Pages, "pages", "merge", "multi-page posts: \"merge\" the pages with separators, or \"split\" them into separate docs")
+	flag.StringVar(&statuses, "status", "publish", "comma-separated list of post statuses to export (publish, draft, pending, private, future)")
+	flag.BoolVar(&opts.Comments, "comments", true, "export approved comments to <post>.comments files, or data files for Hugo and Jekyll")
+	flag.BoolVar(&opts.Pingbacks, "pingbacks", false, "include pingbacks and trackbacks with the comments")
+	flag.BoolVar(&opts.CommentPrivate, "comment-private", false, "include commenter email and IP addresses with the comments")
+	flag.IntVar(&opts.Jobs, "jobs", 4, "number of concurrent attachment downloads")
+	flag.DurationVar(&opts.Timeout, "timeout", 2*time.Minute, "timeout for a single attachment download")
+	flag.IntVar(&opts.Retries, "retries", 3, "how often to retry attachment downloads that fail with transient errors")
+	flag.StringVar(&opts.UploadsDir, "uploads", "", "copy attachments from this local mirror of wp-content/uploads instead of downloading them")
+	flag.BoolVar(&opts.HttpFallback, "http-fallback", false, "with -uploads, download attachments that are missing from the mirror")
+	flag.StringVar(&opts.FrontMatter, "front-matter", "yaml", "front matter format for -format=hugo: \"yaml\" or \"toml\"")
+	flag.StringVar(&report, "report", "unresolved-links.txt", "file to list unresolved links to the blog in, relative to the output directory; empty to disable")
+	flag.Usage = usage
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		usage()
+		os.Exit(2)
+	}
+
+	newBackend := backends[format]
+	if newBackend == nil {
+		fmt.Fprintf(os.Stderr, "-format: unknown output format %q\n", format)
+		os.Exit(2)
+	}
+
+	var err error
+	if opts.Statuses, err = parseStatusList(statuses); err != nil {
+		fmt.Fprintf(os.Stderr, "-status: %s\n", err.Error())
+		os.Exit(2)
+	}
+	if opts.MediaLayout != "flat" && opts.MediaLayout != "uploads" {
+		fmt.Fprintf(os.Stderr, "-media-layout: must be \"flat\" or \"uploads\"\n")
+		os.Exit(2)
+	}
+	if opts.Variants != "full" && opts.Variants != "exact" {
+		fmt.Fprintf(os.Stderr, "-variants: must be \"full\" or \"exact\"\n")
+		os.Exit(2)
+	}
+	if opts.Pages != "merge" && opts.Pages != "split" {
+		fmt.Fprintf(os.Stderr, "-pages: must be \"merge\" or \"split\"\n")
+		os.Exit(2)
+	}
+	if opts.FrontMatter != "yaml" && opts.FrontMatter != "toml" {
+		fmt.Fprintf(os.Stderr, "-front-matter: must be \"yaml\" or \"toml\"\n")
+		os.Exit(2)
+	}
+	if opts.Jobs < 1 {
+		fmt.Fprintf(os.Stderr, "-jobs: need at least one\n")
+		os.Exit(2)
+	}
+	if opts.MediaPath == "" || path.IsAbs(opts.MediaPath) || filepath.IsAbs(opts.MediaPath) {
+		fmt.Fprintf(os.Stderr, "-media: must be a relative path\n")
+		os.Exit(2)
 	}
 
-	blog := convert(&r.Channel)
-	err = process(blog, "c:\\Store\\Blog\\posts")
+	backend := newBackend(&opts)
+	blog, err := convert(flag.Args(), dest, backend, &opts)
 	if err != nil {
-		fmt.Printf("Error writing output: %s\n", err.Error())
+		fmt.Fprintf(os.Stderr, "Error converting: %s\n", err.Error())
+		os.Exit(1)
+	}
+
+	if err = process(blog, dest, backend, &opts); err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing output: %s\n", err.Error())
+		os.Exit(1)
+	}
+
+	if report != "" {
+		fname := filepath.Join(dest, report)
+		file, err := os.Create(fname)
+		if err == nil {
+			err = writeUnresolved(file, blog.Unresolved)
+			if cerr := file.Close(); err == nil {
+				err = cerr
+			}
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing report: %s\n", err.Error())
+			os.Exit(1)
+		}
+		fmt.Printf("%d unresolved link(s), see %q.\n", len(blog.Unresolved), fname)
+	}
+
+	if len(blog.FetchErrors) != 0 {
+		fmt.Fprintf(os.Stderr, "Some attachments couldn't be fetched.\n")
+		os.Exit(1)
 	}
 
 	fmt.Println("done.")
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"bytes"
+	"net/url"
+	"testing"
+)
+
+// Sets up a rewriter for a blog at example.com with one doc per link. Docs
+// get the post ids 1, 2, ... in order.
+func newTestRewriter(opts *Options, links ...string) (*urlRewriter, []*Doc) {
+	u := &urlRewriter{
+		opts:        opts,
+		blog:        &Blog{},
+		backend:     newBlockBackend(opts),
+		selfHosts:   make(map[string]bool),
+		docsByUrl:   make(map[string]*Doc),
+		pageAnchors: make(map[string]string),
+		docsByWpId:  make(map[int]*Doc),
+		attsByUrl:   make(map[string]*Attachment),
+		attsByWpId:  make(map[int]*Attachment),
+		pageDocs:    make(map[*Doc][]*Doc),
+		reported:    make(map[string]bool),
+	}
+	u.addSelfUrl("http://example.com")
+	u.addSelfUrl("https://www.example.com/")
+
+	var docs []*Doc
+	for i, link := range links {
+		doc := &Doc{Id: link, Link: link}
+		u.docsByWpId[i+1] = doc
+		u.addDocUrl(link, doc)
+		docs = append(docs, doc)
+	}
+	return u, docs
+}
+
+func TestUrlKey(t *testing.T) {
+	u, _ := newTestRewriter(&Options{})
+	tests := []struct {
+		url, want string
+	}{
+		{"http://example.com/", ""},
+		{"http://example.com", ""},
+		{"https://www.example.com:443/", ""},
+		{"/", ""},
+		{"http://example.com/2013/01/first/", "/2013/01/first"},
+		{"https://WWW.Example.com/2013/01/first", "/2013/01/first"},
+		{"/2013/01/first/#comments", "/2013/01/first"},
+		{"http://example.com/?p=2", "?p=2"},
+		{"http://example.com/?page_id=3&replytocom=5", "?page_id=3"},
+		{"http://example.com/?page=2&p=2", "?p=2&page=2"},
+		{"http://example.com/?attachment_id=5", "?attachment_id=5"},
+		{"http://example.com/wp-content/uploads/a.jpg?w=300", "/wp-content/uploads/a.jpg"},
+		{"http://other.org/x/", "other.org/x"},
+		{"http://wp.me/p1a-2", "wp.me/p1a-2"},
+	}
+	for _, test := range tests {
+		parsed, err := url.Parse(test.url)
+		if err != nil {
+			t.Fatalf("%q: %s", test.url, err.Error())
+		}
+		if got := u.urlKey(parsed); got != test.want {
+			t.Errorf("%q: want %q but got %q", test.url, test.want, got)
+		}
+	}
+}
+
+func TestLookupDoc(t *testing.T) {
+	tests := []struct {
+		links []string // one doc per link, with post ids 1, 2, ...
+		url   string
+		want  int // index of the doc into links, or -1 for none
+	}{
+		// default permalinks
+		{[]string{"http://example.com/?p=1", "http://example.com/?p=2", "http://example.com/?page_id=3"}, "http://example.com/?p=2", 1},
+		{[]string{"http://example.com/?p=1", "http://example.com/?p=2", "http://example.com/?page_id=3"}, "https://www.example.com/?p=1", 0},
+		{[]string{"http://example.com/?p=1", "http://example.com/?p=2", "http://example.com/?page_id=3"}, "/?page_id=3", 2},
+		{[]string{"http://example.com/?p=1", "http://example.com/?p=2", "http://example.com/?page_id=3"}, "http://example.com/?p=3", 2},
+		{[]string{"http://example.com/?p=1", "http://example.com/?p=2", "http://example.com/?page_id=3"}, "http://example.com/", -1},
+		{[]string{"http://example.com/?p=1", "http://example.com/?p=2", "http://example.com/?page_id=3"}, "http://example.com/?p=4", -1},
+		{[]string{"http://example.com/?p=1", "http://example.com/?p=2", "http://example.com/?page_id=3"}, "http://other.org/?p=1", -1},
+
+		// pretty permalinks
+		{[]string{"http://example.com/first/", "http://example.com/second/"}, "http://example.com/second", 1},
+		{[]string{"http://example.com/first/", "http://example.com/second/"}, "https://www.example.com/first/", 0},
+		{[]string{"http://example.com/first/", "http://example.com/second/"}, "/first/#comments", 0},
+		{[]string{"http://example.com/first/", "http://example.com/second/"}, "http://example.com/?p=2", 1},
+		{[]string{"http://example.com/first/", "http://example.com/second/"}, "http://example.com/", -1},
+		{[]string{"http://example.com/first/", "http://example.com/second/"}, "/", -1},
+		{[]string{"http://example.com/first/", "http://example.com/second/"}, "http://other.org/first/", -1},
+
+		// wp.me shortlinks
+		{[]string{"http://example.com/first/", "http://example.com/second/"}, "http://wp.me/p1a-2", 1},
+		{[]string{"http://example.com/first/", "http://example.com/second/"}, "https://wp.me/P1a-1", 0},
+		{[]string{"http://example.com/first/", "http://example.com/second/"}, "http://wp.me/p1a-3", -1},
+		{[]string{"http://example.com/first/", "http://example.com/second/"}, "http://wp.me/1a", -1},
+	}
+	for _, test := range tests {
+		u, docs := newTestRewriter(&Options{}, test.links...)
+		parsed, err := url.Parse(test.url)
+		if err != nil {
+			t.Fatalf("%q: %s", test.url, err.Error())
+		}
+		var want *Doc
+		if test.want >= 0 {
+			want = docs[test.want]
+		}
+		if got := u.lookupDoc(parsed); got != want {
+			t.Errorf("%q in %q: want %v but got %v", test.url, test.links, want, got)
+		}
+	}
+}
+
+func TestLookupAttachmentPage(t *testing.T) {
+	u, docs := newTestRewriter(&Options{}, "http://example.com/first/")
+	att := &Attachment{PostId: 5, Url: "http://example.com/wp-content/uploads/img.jpg"}
+	u.attsByWpId[att.PostId] = att
+	u.addAttachmentUrl(att.Url, att)
+	u.addAttachmentUrl("http://example.com/?attachment_id=5", att)
+
+	tests := []struct {
+		url  string
+		want *Attachment
+	}{
+		{"http://example.com/?attachment_id=5", att},
+		{"http://example.com/wp-content/uploads/img.jpg", att},
+		{"http://example.com/", nil},
+		{"http://example.com/first/", nil},
+	}
+	for _, test := range tests {
+		parsed, _ := url.Parse(test.url)
+		if got := u.lookupAttachment(parsed); got != test.want {
+			t.Errorf("%q: want %v but got %v", test.url, test.want, got)
+		}
+	}
+	if got := u.UrlRewrite("http://example.com/", "home"); got != "http://example.com/" {
+		t.Errorf("home page link rewritten to %q", got)
+	}
+	if got := u.UrlRewrite("http://example.com/first/", "first"); got != "*"+docs[0].Path() {
+		t.Errorf("doc link rewritten to %q", got)
+	}
+}
+
+func TestExcerptLine(t *testing.T) {
+	tests := []struct {
+		excerpt, want string
+	}{
+		{"", ""},
+		{"One line.", "One line."},
+		{"First.\n\nSecond.\n", "First. Second."},
+		{"Intro:\n\n```cpp\nif (a < b)\n    f();\n```\n\nAfter.", "Intro:"},
+		{"Intro:\n\n    x = 1;\n\nAfter.", "Intro:"},
+		{"- a\n- b", ""},
+		{"Line  \nbreak.", ""},
+	}
+	for _, test := range tests {
+		if got := excerptLine([]byte(test.excerpt)); got != test.want {
+			t.Errorf("%q: want %q but got %q", test.excerpt, test.want, got)
+		}
+	}
+}
+
+func TestMoreExcerpt(t *testing.T) {
+	tests := []struct {
+		html, want string
+	}{
+		{"No marker.", ""},
+		{"Intro.<!--more-->Rest.", "Intro."},
+		{"Intro.\n<!--more-->\nRest.", "Intro."},
+		{"<!-- wp:paragraph -->\n<p>Intro.</p>\n<!-- /wp:paragraph -->\n\n<!-- wp:more -->\n<!--more-->\n<!-- /wp:more -->\n\n<!-- wp:paragraph -->\n<p>Rest.</p>\n<!-- /wp:paragraph -->", "Intro."},
+		{"<p>First.</p>\n<p>Second.</p>\n<!-- wp:more -->\n<!--more-->\n<!-- /wp:more -->\nRest.", "First. Second."},
+	}
+	for _, test := range tests {
+		content, err := ConvertHtmlToMarkdown([]byte(test.html), stubRewriter{})
+		if err != nil {
+			t.Errorf("%q: %s", test.html, err.Error())
+		} else if got := excerptLine(moreExcerpt(content)); got != test.want {
+			t.Errorf("%q: want excerpt %q but got %q", test.html, test.want, got)
+		}
+	}
+}
+
+func TestMergedPages(t *testing.T) {
+	tests := []struct {
+		html, want string
+	}{
+		{"One<!--nextpage-->Two", "One\n\n<hr id=\"page-2\">\n\nTwo"},
+		{"<!--nextpage-->One<!--nextpage-->Two", "One\n\n<hr id=\"page-2\">\n\nTwo"},
+		{"<!--nextpage-->One<!--nextpage-->Two<!--nextpage-->Three", "One\n\n<hr id=\"page-2\">\n\nTwo\n\n<hr id=\"page-3\">\n\nThree"},
+		{"One\n<!--nextpage-->\nTwo", "One\n\n<hr id=\"page-2\">\n\nTwo"},
+		{"\n<!--nextpage-->\nOne\n<!--nextpage-->\nTwo", "One\n\n<hr id=\"page-2\">\n\nTwo"},
+		{"<!-- wp:paragraph -->\n<p>One</p>\n<!-- /wp:paragraph -->\n\n<!-- wp:nextpage -->\n<!--nextpage-->\n<!-- /wp:nextpage -->\n\n<!-- wp:paragraph -->\n<p>Two</p>\n<!-- /wp:paragraph -->",
+			"One\n\n<hr id=\"page-2\">\n\nTwo"},
+		{"One<!-- nextpage -->Two<!--nextpage-->Three", "OneTwo\n\n<hr id=\"page-2\">\n\nThree"},
+	}
+	for _, test := range tests {
+		u, docs := newTestRewriter(&Options{Pages: "merge"}, "http://example.com/first/")
+		content := []byte(test.html)
+		u.handlePages(docs, map[*Doc]int{docs[0]: len(splitPages(content))})
+		u.setPageContent(docs[0], content)
+
+		got, err := ConvertHtmlToMarkdown(docs[0].ContentHtml, u)
+		if err != nil {
+			t.Errorf("%q: %s", test.html, err.Error())
+		} else if got = bytes.Trim(got, "\n"); string(got) != test.want {
+			t.Errorf("%q: want %q but got %q", test.html, test.want, string(got))
+		}
+		if link := u.UrlRewrite("http://example.com/first/2/", "page 2"); link != "*"+docs[0].Path()+"#page-2" {
+			t.Errorf("%q: page 2 link rewritten to %q", test.html, link)
+		}
+	}
+}
+
+func TestScaledImageVariants(t *testing.T) {
+	u, _ := newTestRewriter(&Options{Variants: "full"})
+	att := &Attachment{PostId: 5, Url: "http://example.com/wp-content/uploads/2020/01/big-scaled.jpg"}
+	u.attsByWpId[att.PostId] = att
+	u.addAttachmentUrl(att.Url, att)
+
+	tests := []struct {
+		url  string
+		want *Attachment
+	}{
+		{"http://example.com/wp-content/uploads/2020/01/big-scaled.jpg", att},
+		{"http://example.com/wp-content/uploads/2020/01/big-300x200.jpg", att},
+		{"/wp-content/uploads/2020/01/big-1024x683.jpg", att},
+		{"http://example.com/wp-content/uploads/2020/01/big.jpg", att},
+		{"http://example.com/wp-content/uploads/2020/01/other-300x200.jpg", nil},
+	}
+	for _, test := range tests {
+		parsed, _ := url.Parse(test.url)
+		if got := u.lookupAttachment(parsed); got != test.want {
+			t.Errorf("%q: want %v but got %v", test.url, test.want, got)
+		}
+	}
+}
+
+func TestSplitPages(t *testing.T) {
+	u, docs := newTestRewriter(&Options{Pages: "split"}, "http://example.com/first/")
+	docs[0].Id = "first"
+	content := []byte("One\n<!--nextpage-->\nTwo\n<!--nextpage-->\nThree")
+	all := u.handlePages(docs, map[*Doc]int{docs[0]: len(splitPages(content))})
+	if len(all) != 3 {
+		t.Fatalf("want 3 docs but got %d", len(all))
+	}
+	if got := u.setPageContent(docs[0], content); len(got) != 3 || got[0] != docs[0] || got[1] != all[1] || got[2] != all[2] {
+		t.Fatalf("setPageContent returned %v", got)
+	}
+
+	tests := []struct {
+		doc  *Doc
+		want string
+	}{
+		{all[0], "One\n\n[Next page](*first/page-2)"},
+		{all[1], "Two\n\n[Previous page](*first) · [Next page](*first/page-3)"},
+		{all[2], "Three\n\n[Previous page](*first/page-2)"},
+	}
+	for _, test := range tests {
+		got, err := ConvertHtmlToMarkdown(test.doc.ContentHtml, u)
+		if err != nil {
+			t.Errorf("%s: %s", test.doc.Id, err.Error())
+		} else if got = bytes.Trim(got, "\n"); string(got) != test.want {
+			t.Errorf("%s: want %q but got %q", test.doc.Id, test.want, string(got))
+		}
+	}
+}
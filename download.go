@@ -0,0 +1,283 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type downloadError struct {
+	Url       string
+	Err       error
+	Transient bool // worth retrying?
+}
+
+func (e *downloadError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Url, e.Err.Error())
+}
+
+// A mediaSource gets the contents of attachments into local files.
+//
+// Files are written to a ".part" file that is only renamed to its final
+// name once it's complete, so existing files can be skipped and partial
+// files from interrupted runs can be resumed.
+type mediaSource interface {
+	Fetch(att *Attachment, fname string) error
+}
+
+// Where self-hosted blogs keep their media.
+const uploadsPrefix = "/wp-content/uploads/"
+
+// Returned by sources that don't have an attachment, as opposed to failing
+// to get it.
+type notInSourceError struct {
+	Name string
+}
+
+func (e *notInSourceError) Error() string {
+	return e.Name + ": not found"
+}
+
+// Builds the media source described by the options: a local uploads
+// mirror, the live site, or the mirror with the live site as fallback.
+func newMediaSource(opts *Options) mediaSource {
+	web := &httpSource{
+		client:  &http.Client{Timeout: opts.Timeout},
+		retries: opts.Retries,
+	}
+	if opts.UploadsDir == "" {
+		return web
+	}
+
+	local := &localSource{root: opts.UploadsDir}
+	if !opts.HttpFallback {
+		return local
+	}
+	return fallbackSource{local, web}
+}
+
+// Fetches all attachments that are in use into the media directory, using
+// up to opts.Jobs concurrent workers. Failures don't stop the other
+// attachments; they're all returned at the end.
+func fetchAttachments(atts []*Attachment, media string, src mediaSource, opts *Options) []error {
+	queue := make(chan *Attachment)
+
+	var mu sync.Mutex
+	var errs []error
+
+	var wg sync.WaitGroup
+	for i := 0; i < opts.Jobs; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for att := range queue {
+				fname := filepath.Join(media, filepath.FromSlash(att.Filename))
+				if _, err := os.Stat(fname); err == nil {
+					continue // already have it
+				}
+				err := os.MkdirAll(filepath.Dir(fname), 0755)
+				if err == nil {
+					err = src.Fetch(att, fname)
+				}
+				if err != nil {
+					fmt.Printf("failed to fetch %q: %s\n", att.Filename, err.Error())
+					mu.Lock()
+					errs = append(errs, err)
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+
+	for _, att := range atts {
+		if att.Filename != "" {
+			queue <- att
+		}
+	}
+	close(queue)
+	wg.Wait()
+
+	return errs
+}
+
+// Tries each source in turn until one has the attachment.
+type fallbackSource []mediaSource
+
+func (f fallbackSource) Fetch(att *Attachment, fname string) (err error) {
+	for _, src := range f {
+		err = src.Fetch(att, fname)
+		if _, missing := err.(*notInSourceError); !missing {
+			break
+		}
+	}
+	return
+}
+
+// Copies attachments from a local mirror of the "wp-content/uploads"
+// directory.
+type localSource struct {
+	root string
+}
+
+// Returns the path of an uploaded file relative to the uploads directory,
+// e.g. "2013/07/foo.jpg". Self-hosted blogs keep uploads below
+// "/wp-content/uploads/", Wordpress.com serves them from the root of the
+// files host.
+func uploadsPath(urlPath string) string {
+	if i := strings.Index(urlPath, uploadsPrefix); i != -1 {
+		urlPath = urlPath[i+len(uploadsPrefix):]
+	}
+	// path.Clean on an absolute path also gets rid of leading ".."s
+	return strings.TrimPrefix(path.Clean("/"+urlPath), "/")
+}
+
+// Maps an attachment URL onto the uploads mirror.
+func (s *localSource) localPath(rawurl string) (string, error) {
+	parsed, err := url.Parse(rawurl)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(s.root, filepath.FromSlash(uploadsPath(parsed.Path))), nil
+}
+
+func (s *localSource) Fetch(att *Attachment, fname string) error {
+	srcName, err := s.localPath(att.Url)
+	if err != nil {
+		return err
+	}
+
+	in, err := os.Open(srcName)
+	if os.IsNotExist(err) {
+		return &notInSourceError{Name: srcName}
+	} else if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	partName := fname + ".part"
+	out, err := os.Create(partName)
+	if err != nil {
+		return err
+	}
+	written, err := io.Copy(out, in)
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(partName, fname)
+	}
+	if err != nil {
+		os.Remove(partName)
+		return err
+	}
+
+	fmt.Printf("copied %q, %d bytes.\n", att.Filename, written)
+	return nil
+}
+
+// Downloads attachments from the live site.
+type httpSource struct {
+	client  *http.Client
+	retries int // for transient errors
+}
+
+func (s *httpSource) Fetch(att *Attachment, fname string) error {
+	backoff := time.Second
+	for attempt := 0; ; attempt++ {
+		written, err := download(s.client, att.Url, fname)
+		if err == nil {
+			fmt.Printf("fetched %q, %d bytes.\n", att.Filename, written)
+			return nil
+		}
+
+		derr, ok := err.(*downloadError)
+		if !ok || !derr.Transient || attempt >= s.retries {
+			// don't leave empty partial files around
+			if fi, serr := os.Stat(fname + ".part"); serr == nil && fi.Size() == 0 {
+				os.Remove(fname + ".part")
+			}
+			return err
+		}
+
+		fmt.Printf("retrying %q in %s: %s\n", att.Filename, backoff, derr.Err.Error())
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// Downloads url to fname, picking up where a previous attempt left off if
+// there's a partial download and the server supports range requests.
+// Returns the number of bytes written in this attempt.
+func download(client *http.Client, url, fname string) (int64, error) {
+	partName := fname + ".part"
+	file, err := os.OpenFile(partName, os.O_WRONLY|os.O_CREATE, 0644)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	offset, err := file.Seek(0, io.SeekEnd)
+	if err != nil {
+		return 0, err
+	}
+
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return 0, &downloadError{Url: url, Err: err}
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, &downloadError{Url: url, Err: err, Transient: true}
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusPartialContent && offset > 0:
+		if !strings.HasPrefix(resp.Header.Get("Content-Range"), "bytes "+strconv.FormatInt(offset, 10)+"-") {
+			file.Truncate(0)
+			return 0, &downloadError{Url: url, Err: fmt.Errorf("unexpected Content-Range %q", resp.Header.Get("Content-Range")), Transient: true}
+		}
+	case resp.StatusCode == http.StatusRequestedRangeNotSatisfiable && offset > 0:
+		// Our partial file doesn't match what's on the server; start over.
+		file.Truncate(0)
+		return 0, &downloadError{Url: url, Err: fmt.Errorf("HTTP error %s", resp.Status), Transient: true}
+	case resp.StatusCode >= 200 && resp.StatusCode <= 299:
+		// Full response, start from scratch.
+		if err = file.Truncate(0); err != nil {
+			return 0, err
+		}
+		if _, err = file.Seek(0, io.SeekStart); err != nil {
+			return 0, err
+		}
+	default:
+		code := resp.StatusCode
+		transient := code >= 500 || code == http.StatusTooManyRequests || code == http.StatusRequestTimeout
+		return 0, &downloadError{Url: url, Err: fmt.Errorf("HTTP error %s", resp.Status), Transient: transient}
+	}
+
+	written, err := io.Copy(file, resp.Body)
+	if err == nil && resp.ContentLength >= 0 && written != resp.ContentLength {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		// keep the partial file around so the next attempt can resume
+		return written, &downloadError{Url: url, Err: err, Transient: true}
+	}
+
+	if err = file.Close(); err != nil {
+		return written, err
+	}
+	return written, os.Rename(partName, fname)
+}
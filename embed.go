@@ -0,0 +1,209 @@
+package main
+
+import (
+	"code.google.com/p/go.net/html"
+	"code.google.com/p/go.net/html/atom"
+	"github.com/rygorous/wp2block/shortcode"
+	"regexp"
+	"strings"
+)
+
+// Media embedded from another site, identified by provider and the ids
+// the provider needs. Everything is derived from the URL, so no network
+// access is needed.
+type embed struct {
+	Provider string
+	User     string // for tweets
+	Id       string
+}
+
+type embedProvider struct {
+	name  string
+	urlRe *regexp.Regexp // submatches are the user (optional) and the id
+	user  bool           // whether the first submatch is the user
+}
+
+// The providers we know how to embed, matched against the URL of the
+// media page or the player.
+var embedProviders = []*embedProvider{
+	{name: "youtube", urlRe: regexp.MustCompile(`^https?://(?:(?:www\.|m\.)?youtube(?:-nocookie)?\.com/(?:watch\?(?:.*&)?v=|embed/|v/|shorts/)|youtu\.be/)([\w-]+)`)},
+	{name: "vimeo", urlRe: regexp.MustCompile(`^https?://(?:www\.|player\.)?vimeo\.com/(?:video/|channels/[\w-]+/)?(\d+)`)},
+	{name: "twitter", urlRe: regexp.MustCompile(`^https?://(?:www\.|mobile\.)?(?:twitter|x)\.com/(\w+)/status(?:es)?/(\d+)`), user: true},
+}
+
+var bareIdRe = regexp.MustCompile(`^[\w-]+$`)
+
+// Returns the embed for a URL, or nil if it isn't from a known provider.
+func lookupEmbed(url string) *embed {
+	url = strings.TrimSpace(url)
+	if strings.HasPrefix(url, "//") {
+		url = "https:" + url // protocol-relative player URLs
+	}
+	for _, p := range embedProviders {
+		m := p.urlRe.FindStringSubmatch(url)
+		if m == nil {
+			continue
+		}
+		if p.user {
+			return &embed{Provider: p.name, User: m[1], Id: m[2]}
+		}
+		return &embed{Provider: p.name, Id: m[1]}
+	}
+	return nil
+}
+
+// Wordpress embeds URLs that are on a line of their own ("auto-embeds").
+// This turns those lines into "embed" shortcode nodes, same as if they'd
+// been written as "[embed]url[/embed]".
+func processAutoEmbeds(node *html.Node) {
+	for n := node.FirstChild; n != nil; n = n.NextSibling {
+		switch n.Type {
+		case html.TextNode:
+			n = splitAutoEmbeds(n)
+		case html.ElementNode:
+			if n.Namespace == shortcode.Namespace {
+				continue // shortcodes have their own rules
+			}
+			switch n.DataAtom {
+			case atom.A, atom.Pre, atom.Code:
+				// not for embedding
+			default:
+				processAutoEmbeds(n)
+			}
+		}
+	}
+}
+
+// Splits the auto-embeds out of a text node. Returns the last node the
+// text ends up in.
+func splitAutoEmbeds(n *html.Node) *html.Node {
+	startsLine := isPrevBlockBoundary(n) || isBr(n.PrevSibling) || isEmbed(n.PrevSibling)
+	endsLine := isNextBlockBoundary(n) || isBr(n.NextSibling) || isEmbed(n.NextSibling)
+
+	lines := strings.Split(n.Data, "\n")
+	for i, line := range lines {
+		if (i == 0 && !startsLine) || (i == len(lines)-1 && !endsLine) {
+			continue
+		}
+		url := strings.TrimSpace(line)
+		if url == "" || lookupEmbed(url) == nil {
+			continue
+		}
+
+		// the line breaks around the URL go, too; the embed is a block
+		// of its own.
+		before := strings.TrimRight(strings.Join(lines[:i], "\n"), "\n")
+		after := strings.TrimLeft(strings.Join(lines[i+1:], "\n"), "\n")
+
+		tag := &html.Node{
+			Type:      html.ElementNode,
+			Data:      "embed",
+			Namespace: shortcode.Namespace,
+		}
+		tag.AppendChild(&html.Node{Type: html.TextNode, Data: url})
+		rest := &html.Node{Type: html.TextNode, Data: after}
+
+		n.Data = before
+		n.Parent.InsertBefore(tag, n.NextSibling)
+		n.Parent.InsertBefore(rest, tag.NextSibling)
+		if strings.TrimSpace(before) == "" && isBr(n.PrevSibling) {
+			n.Parent.RemoveChild(n.PrevSibling)
+		}
+		if br := rest.NextSibling; strings.TrimSpace(after) == "" && isBr(br) {
+			// the source line break after the "<br>" isn't a break of
+			// its own
+			if next := br.NextSibling; next != nil && next.Type == html.TextNode {
+				next.Data = strings.TrimLeft(next.Data, "\n")
+			}
+			n.Parent.RemoveChild(br)
+		}
+		return splitAutoEmbeds(rest)
+	}
+	return n
+}
+
+func isBr(n *html.Node) bool {
+	return n != nil && n.Type == html.ElementNode && n.DataAtom == atom.Br
+}
+
+func isEmbed(n *html.Node) bool {
+	return n != nil && n.Namespace == shortcode.Namespace && n.Data == "embed"
+}
+
+// Handles the "[embed]", "[youtube]", "[vimeo]" and "[tweet]" shortcodes.
+// Links to unknown providers are kept as plain links.
+func handleEmbedShortcode(w *writer, node *html.Node) {
+	url := strings.TrimSpace(textContent(node))
+	for _, key := range []string{"", "@0", "url", "id"} {
+		if url == "" {
+			url = attr(node, key) // "[youtube=url]", "[youtube url]" etc.
+		}
+	}
+
+	e := lookupEmbed(url)
+	if e == nil && bareIdRe.MatchString(url) {
+		// "[vimeo 123]", "[youtube abc]" and "[tweet 123]" take bare ids,
+		// too. Twitter finds the user for "i".
+		switch node.Data {
+		case "youtube", "vimeo":
+			e = &embed{Provider: node.Data, Id: url}
+		case "tweet":
+			e = lookupEmbed("https://twitter.com/i/status/" + url)
+		}
+	}
+
+	if e != nil {
+		writeEmbed(w, e)
+	} else if url != "" {
+		w.EnsureLinefeeds(2)
+		if autolinkRe.MatchString(url) {
+			surround(w, "<", []byte(url), ">", "<>")
+		} else {
+			markdownEscape(w, []byte(url), escapedCharsAll)
+		}
+		w.EnsureLinefeeds(2)
+	}
+}
+
+// URLs that work as Markdown autolinks.
+var autolinkRe = regexp.MustCompile(`^https?://[^\s<>]+$`)
+
+// Writes an embed as
+//
+//	{% embed <provider> [user=<user>] id=<id> %}
+//
+// on a line of its own; backends turn it into whatever their site
+// generator uses.
+func writeEmbed(w *writer, e *embed) {
+	w.EnsureLinefeeds(2)
+	w.WriteString("{% embed " + e.Provider)
+	if e.User != "" {
+		w.WriteString(" user=" + e.User)
+	}
+	w.WriteString(" id=" + e.Id + " %}")
+	w.EnsureLinefeeds(2)
+}
+
+var embedRe = regexp.MustCompile(`\{% embed (\w+)(?: user=(\w+))? id=([\w-]+) %\}`)
+
+// Replaces the "{% embed %}" tags in content with the result of render.
+func replaceEmbeds(content []byte, render func(e *embed) string) []byte {
+	return embedRe.ReplaceAllFunc(content, func(match []byte) []byte {
+		m := embedRe.FindSubmatch(match)
+		return []byte(render(&embed{Provider: string(m[1]), User: string(m[2]), Id: string(m[3])}))
+	})
+}
+
+// Renders an embed as plain HTML: an iframe with the provider's player, or
+// for tweets the blockquote Twitter's widget script picks up.
+func embedHtml(e *embed) string {
+	switch e.Provider {
+	case "youtube":
+		return `<iframe width="560" height="315" src="https://www.youtube-nocookie.com/embed/` + e.Id + `" frameborder="0" allowfullscreen></iframe>`
+	case "vimeo":
+		return `<iframe width="640" height="360" src="https://player.vimeo.com/video/` + e.Id + `" frameborder="0" allowfullscreen></iframe>`
+	case "twitter":
+		return `<blockquote class="twitter-tweet"><a href="https://twitter.com/` + e.User + `/status/` + e.Id + `"></a></blockquote>`
+	}
+	return ""
+}
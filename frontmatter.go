@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Front matter for static site generators, written either as YAML or as
+// TOML. Values are strings, bools, times or string lists; entries are
+// written in the order they were added.
+type frontMatter struct {
+	toml    bool
+	entries []frontMatterEntry
+}
+
+type frontMatterEntry struct {
+	key   string
+	value interface{}
+}
+
+func (f *frontMatter) Add(key string, value interface{}) {
+	f.entries = append(f.entries, frontMatterEntry{key, value})
+}
+
+// Adds a string list, but only if it's non-empty.
+func (f *frontMatter) AddList(key string, values []string) {
+	if len(values) != 0 {
+		f.Add(key, values)
+	}
+}
+
+func (f *frontMatter) WriteTo(wr io.Writer) (int64, error) {
+	delim, sep := "---", ": "
+	if f.toml {
+		delim, sep = "+++", " = "
+	}
+
+	var out strings.Builder
+	out.WriteString(delim + "\n")
+	for _, e := range f.entries {
+		out.WriteString(e.key + sep)
+		switch v := e.value.(type) {
+		case string:
+			out.WriteString(quoteFrontMatter(v))
+		case bool:
+			fmt.Fprintf(&out, "%t", v)
+		case time.Time:
+			out.WriteString(v.Format(time.RFC3339))
+		case []string:
+			quoted := make([]string, len(v))
+			for i, s := range v {
+				quoted[i] = quoteFrontMatter(s)
+			}
+			out.WriteString("[" + strings.Join(quoted, ", ") + "]")
+		default:
+			panic(fmt.Sprintf("unsupported front matter value %#v", v))
+		}
+		out.WriteString("\n")
+	}
+	out.WriteString(delim + "\n")
+
+	n, err := io.WriteString(wr, out.String())
+	return int64(n), err
+}
+
+// Writes the comments on a doc as a YAML list for the data directory of
+// a static site generator. The keys match the headers of writeComments;
+// empty values are omitted.
+func writeCommentData(wr io.Writer, comments []*Comment) error {
+	var out strings.Builder
+	for _, com := range comments {
+		fmt.Fprintf(&out, "- id: %d\n", com.Id)
+		if com.Parent != 0 {
+			fmt.Fprintf(&out, "  parent: %d\n", com.Parent)
+		}
+		for _, kv := range []struct{ key, value string }{
+			{"author", com.Author},
+			{"url", com.AuthorUrl},
+			{"email", com.AuthorEmail},
+			{"ip", com.AuthorIp},
+		} {
+			if kv.value != "" {
+				out.WriteString("  " + kv.key + ": " + quoteFrontMatter(kv.value) + "\n")
+			}
+		}
+		out.WriteString("  time: " + com.Date.Format(time.RFC3339) + "\n")
+		if com.Type == CommentPingback {
+			out.WriteString("  type: pingback\n")
+		}
+		out.WriteString("  content: " + quoteFrontMatter(string(com.Content)) + "\n")
+	}
+
+	_, err := io.WriteString(wr, out.String())
+	return err
+}
+
+// Quotes a string such that it's valid in both YAML and TOML: double
+// quotes, with backslash escapes for quotes, backslashes and control
+// characters only.
+func quoteFrontMatter(s string) string {
+	var out strings.Builder
+	out.WriteByte('"')
+	for _, r := range s {
+		switch {
+		case r == '"' || r == '\\':
+			out.WriteByte('\\')
+			out.WriteRune(r)
+		case r == '\n':
+			out.WriteString(`\n`)
+		case r == '\t':
+			out.WriteString(`\t`)
+		case r < 0x20 || r == 0x7f || r == utf8.RuneError:
+			fmt.Fprintf(&out, `\u%04x`, r)
+		default:
+			out.WriteRune(r)
+		}
+	}
+	out.WriteByte('"')
+	return out.String()
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestCommentData(t *testing.T) {
+	date := time.Date(2013, 1, 2, 3, 4, 5, 0, time.UTC)
+	comments := []*Comment{
+		{Id: 1, Author: `Jane "J" Doe`, AuthorUrl: "http://jane.example.com/", Date: date, Content: []byte("Nice.\n\n`x\\y`")},
+		{Id: 2, Parent: 1, Date: date, Content: []byte("Thanks!")},
+		{Id: 3, Type: CommentPingback, Author: "Other blog", Date: date, Content: []byte("[...] linked [...]")},
+	}
+	want := `- id: 1
+  author: "Jane \"J\" Doe"
+  url: "http://jane.example.com/"
+  time: 2013-01-02T03:04:05Z
+  content: "Nice.\n\n` + "`x\\\\y`" + `"
+- id: 2
+  parent: 1
+  time: 2013-01-02T03:04:05Z
+  content: "Thanks!"
+- id: 3
+  author: "Other blog"
+  time: 2013-01-02T03:04:05Z
+  type: pingback
+  content: "[...] linked [...]"
+`
+	var buf bytes.Buffer
+	if err := writeCommentData(&buf, comments); err != nil {
+		t.Fatal(err)
+	}
+	if got := buf.String(); got != want {
+		t.Errorf("want\n%s\nbut got\n%s", want, got)
+	}
+}
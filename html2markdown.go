@@ -9,12 +9,18 @@ import (
 	"github.com/rygorous/wp2block/shortcode"
 	"net/url"
 	"regexp"
+	"strconv"
 	"strings"
+	"unicode"
 	"unicode/utf8"
 )
 
 type UrlRewriter interface {
-	UrlRewrite(url string) string
+	// Rewrites a link or image URL; text is the link text or alt text.
+	UrlRewrite(url, text string) string
+
+	// Returns the attachments shown by a "[gallery]" shortcode.
+	GalleryAttachments(ids, exclude []int) []*Attachment
 }
 
 func ConvertHtmlToMarkdown(in []byte, rewriteUrl UrlRewriter) ([]byte, error) {
@@ -25,7 +31,10 @@ func ConvertHtmlToMarkdown(in []byte, rewriteUrl UrlRewriter) ([]byte, error) {
 		Data:     "body",
 	}
 
-	reader := bytes.NewReader(in)
+	// code in shortcodes must not go through the HTML parser.
+	src, verbatim := shortcode.ExtractVerbatim(string(in))
+
+	reader := strings.NewReader(src)
 	elems, err := html.ParseFragment(reader, body)
 	if err != nil {
 		return nil, err
@@ -44,6 +53,8 @@ func ConvertHtmlToMarkdown(in []byte, rewriteUrl UrlRewriter) ([]byte, error) {
 		return nil, err
 	}
 	shortcode.ProcessWpLatex(body)
+	processAutoEmbeds(body)
+	shortcode.RestoreVerbatim(body, verbatim)
 
 	// render it back
 	wr := &writer{RewriteUrl: rewriteUrl}
@@ -62,6 +73,7 @@ type writer struct {
 	Verbatim   int // if >0, don't do any processing on output newlines
 	RewriteUrl UrlRewriter
 
+	pageBreaks   int // number of "nextpage" markers seen so far
 	lfRunCounter int // length of the current run of line feeds written
 	lfRunTarget  int // target length of current run of line feeds
 	out          bytes.Buffer
@@ -238,6 +250,16 @@ var headingPrefix = [...]string{
 	"###### ",
 }
 
+// Separates the excerpt from the rest of a post.
+const moreMarker = "<!--more-->"
+
+// Wordpress allows custom "read more" link text after the marker, as in
+// "<!--more Keep reading-->".
+func isMoreComment(n *html.Node) bool {
+	data := strings.TrimSpace(n.Data)
+	return data == "more" || strings.HasPrefix(data, "more ")
+}
+
 func renderElement(w *writer, n *html.Node, listIndex int) error {
 	switch n.Type {
 	case html.ErrorNode:
@@ -254,8 +276,20 @@ func renderElement(w *writer, n *html.Node, listIndex int) error {
 	case html.ElementNode:
 		// nothing.
 	case html.CommentNode:
-		if n.Data == "more" {
-			// the split marker we potentially care about
+		if isMoreComment(n) {
+			// the split marker we care about; passed through as-is, since
+			// that's what most static site generators expect.
+			w.EnsureLinefeeds(2)
+			w.WriteString(moreMarker)
+			w.EnsureLinefeeds(2)
+		} else if n.Data == "nextpage" {
+			// A visible separator for links to the page to point at.
+			// Wordpress only takes the exact marker, and handlePages
+			// already dropped one at the very start.
+			w.pageBreaks++
+			w.EnsureLinefeeds(2)
+			fmt.Fprintf(w, "<hr id=\"page-%d\">", w.pageBreaks+1)
+			w.EnsureLinefeeds(2)
 		}
 		return nil
 	case html.DoctypeNode:
@@ -278,34 +312,20 @@ func renderElement(w *writer, n *html.Node, listIndex int) error {
 	case atom.Strong, atom.B:
 		return renderContents(w, "**", n, "**")
 	case atom.Code:
-		if contents := tryLeafChildText(n); contents != nil {
-			if bytes.IndexByte(contents, '`') == -1 {
-				w.Verbatim++
-				surround(w, "`", contents, "`", "")
-				w.Verbatim--
-				return nil
-			}
+		if contents := tryLeafChildText(n); contents != nil && len(contents) != 0 {
+			writeCodeSpan(w, contents)
+			return nil
 		}
 	case atom.Pre:
-		if contents := tryLeafChildText(n); contents != nil {
-			if bytes.Index(contents, []byte("```")) == -1 {
-				contents = tabsToSpaces(contents, 8)
-				w.EnsureLinefeeds(2)
-				w.WriteString("```\n")
-				w.Verbatim++
-				surround(w, "", contents, "", "")
-				w.Verbatim--
-				w.EnsureLinefeeds(1)
-				w.WriteString("```")
-				w.EnsureLinefeeds(2)
-				return nil
-			}
-		}
+		// syntax highlighting markup and the like is dropped; code
+		// always becomes a code block.
+		writeCodeBlock(w, preLanguage(n), []byte(preText(n)))
+		return nil
 	case atom.A:
 		if isSimpleLink(n) {
 			text := leafChildText(n)
 			href := attr(n, "href")
-			href = w.RewriteUrl.UrlRewrite(href)
+			href = w.RewriteUrl.UrlRewrite(href, string(text))
 			surround(w, "[", text, "]", "[]")
 			surround(w, "(", []byte(href), ")", "()")
 			return nil
@@ -369,6 +389,19 @@ func renderElement(w *writer, n *html.Node, listIndex int) error {
 		return renderContents(w, "<"+n.Data+">", n, "</"+n.Data+">")
 	case atom.Br:
 		w.WriteString("<br>\n")
+		return nil
+	case atom.Audio, atom.Video:
+		handleMediaElement(w, n)
+		return nil
+	case atom.Iframe:
+		if e := lookupEmbed(attr(n, "src")); e != nil {
+			writeEmbed(w, e)
+			return nil
+		}
+	case atom.Table:
+		if ok, err := handleTable(w, n); ok || err != nil {
+			return err
+		}
 	}
 
 	if n.Namespace == shortcode.Namespace {
@@ -384,12 +417,24 @@ func renderElement(w *writer, n *html.Node, listIndex int) error {
 			return nil
 		case "caption", "wp_caption":
 			return handleWpCaption(w, n)
+		case "sourcecode", "source", "code":
+			return handleCodeShortcode(w, n)
+		case "gallery":
+			handleGallery(w, n)
+			return nil
+		case "embed", "youtube", "vimeo", "tweet":
+			handleEmbedShortcode(w, n)
+			return nil
+		case "audio", "video":
+			handleMediaShortcode(w, n)
+			return nil
 		default:
 			return fmt.Errorf("unhandled shortcode %q", n.Data)
 		}
 	}
 
 	// By default, fall back to rendering as HTML
+	rewriteHtmlUrls(w, n)
 	w.Verbatim++
 	//fmt.Printf("unhandled %s\n", n.Data)
 	err := html.Render(w, n)
@@ -397,6 +442,44 @@ func renderElement(w *writer, n *html.Node, listIndex int) error {
 	return err
 }
 
+// Rewrites the URLs in a subtree that is about to be rendered as HTML, so
+// it doesn't point at the old site.
+func rewriteHtmlUrls(w *writer, node *html.Node) {
+	if node.Type == html.ElementNode {
+		text := attr(node, "alt")
+		if node.DataAtom == atom.A {
+			text = strings.TrimSpace(textContent(node))
+		}
+		for i := range node.Attr {
+			attr := &node.Attr[i]
+			switch attr.Key {
+			case "href", "src":
+				attr.Val = w.RewriteUrl.UrlRewrite(attr.Val, text)
+			case "srcset":
+				attr.Val = rewriteSrcset(w, attr.Val, text)
+			}
+		}
+	}
+	for n := node.FirstChild; n != nil; n = n.NextSibling {
+		rewriteHtmlUrls(w, n)
+	}
+}
+
+// Rewrites the candidate URLs in a srcset attribute, which is a
+// comma-separated list of URLs followed by optional size descriptors.
+func rewriteSrcset(w *writer, srcset, text string) string {
+	candidates := strings.Split(srcset, ",")
+	for i, cand := range candidates {
+		fields := strings.Fields(cand)
+		if len(fields) == 0 {
+			continue
+		}
+		fields[0] = w.RewriteUrl.UrlRewrite(fields[0], text)
+		candidates[i] = strings.Join(fields, " ")
+	}
+	return strings.Join(candidates, ", ")
+}
+
 func renderContents(w *writer, prefix string, node *html.Node, suffix string) error {
 	w.WriteString(prefix)
 	for n := node.FirstChild; n != nil; n = n.NextSibling {
@@ -445,6 +528,383 @@ func handleText(w *writer, text string) error {
 	return nil
 }
 
+// Returns the length of the longest run of backticks in b.
+func longestBacktickRun(b []byte) int {
+	longest, run := 0, 0
+	for _, c := range b {
+		if c == '`' {
+			run++
+			if run > longest {
+				longest = run
+			}
+		} else {
+			run = 0
+		}
+	}
+	return longest
+}
+
+// Writes a fenced code block, tagged with the language if there is one. As
+// per CommonMark, the fence is made longer than any run of backticks in the
+// code, so the code can't close it.
+func writeCodeBlock(w *writer, lang string, contents []byte) {
+	fenceLen := longestBacktickRun(contents) + 1
+	if fenceLen < 3 {
+		fenceLen = 3
+	}
+	fence := strings.Repeat("`", fenceLen)
+	lang = strings.Map(func(r rune) rune {
+		if r == '`' || unicode.IsSpace(r) {
+			return -1 // not allowed in the info string
+		}
+		return r
+	}, lang)
+
+	contents = tabsToSpaces(contents, 8)
+	w.EnsureLinefeeds(2)
+	w.WriteString(fence + lang + "\n")
+	w.Verbatim++
+	surround(w, "", contents, "", "")
+	w.Verbatim--
+	w.EnsureLinefeeds(1)
+	w.WriteString(fence)
+	w.EnsureLinefeeds(2)
+}
+
+// Writes an inline code span, delimited by a backtick run that doesn't
+// occur in the code. CommonMark strips one space from both ends, so code
+// that starts or ends with a backtick or a space gets padded.
+func writeCodeSpan(w *writer, contents []byte) {
+	delim := strings.Repeat("`", longestBacktickRun(contents)+1)
+	start, end := delim, delim
+	first, last := contents[0], contents[len(contents)-1]
+	if first == '`' || last == '`' || (first == ' ' && last == ' ' && len(bytes.TrimSpace(contents)) != 0) {
+		start, end = delim+" ", " "+delim
+	}
+
+	w.Verbatim++
+	surround(w, start, contents, end, "")
+	w.Verbatim--
+}
+
+// Returns the code in a "pre" element as plain text. Markup from syntax
+// highlighters is dropped, "br"s and block-level elements become line
+// breaks.
+func preText(node *html.Node) string {
+	var buf bytes.Buffer
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		switch {
+		case n.Type == html.TextNode:
+			buf.WriteString(n.Data)
+		case n.Type == html.ElementNode && n.DataAtom == atom.Br:
+			buf.WriteByte('\n')
+		case n.Namespace == shortcode.Namespace && n.Data == "latex":
+			// undo ProcessWpLatex
+			buf.WriteString("$latex " + textContent(n) + "$")
+			return
+		}
+		for kid := n.FirstChild; kid != nil; kid = kid.NextSibling {
+			walk(kid)
+		}
+		if n != node && isBlockLevelElement(n) && buf.Len() != 0 && buf.Bytes()[buf.Len()-1] != '\n' {
+			buf.WriteByte('\n')
+		}
+	}
+	walk(node)
+	return buf.String()
+}
+
+var brushRe = regexp.MustCompile(`(?:^|;)\s*brush\s*:\s*([^;\s]+)`)
+
+// Returns the language of a "pre" element, from the SyntaxHighlighter
+// ("brush: cpp") or WP-Syntax (lang="cpp") markup, or a "language-cpp"
+// class on it or a "code" element inside.
+func preLanguage(node *html.Node) string {
+	if lang := attr(node, "lang"); lang != "" {
+		return strings.ToLower(lang)
+	}
+	class := attr(node, "class")
+	if m := brushRe.FindStringSubmatch(class); m != nil {
+		return strings.ToLower(m[1])
+	}
+	if code := node.FirstChild; code != nil && code == node.LastChild && code.DataAtom == atom.Code {
+		class += " " + attr(code, "class")
+	}
+	for _, cls := range strings.Fields(class) {
+		if strings.HasPrefix(cls, "language-") {
+			return strings.ToLower(strings.TrimPrefix(cls, "language-"))
+		}
+	}
+	return ""
+}
+
+// Handles the "[sourcecode]" shortcodes of SyntaxHighlighter Evolved and
+// Wordpress.com. Their body is the code exactly as written, see
+// shortcode.ExtractVerbatim.
+func handleCodeShortcode(w *writer, node *html.Node) error {
+	lang := attr(node, "language")
+	if lang == "" {
+		lang = attr(node, "lang")
+	}
+	if lang == "" {
+		lang = attr(node, "@0") // "[sourcecode cpp]"
+	}
+	lang = strings.ToLower(lang)
+
+	code := strings.Replace(textContent(node), "\r\n", "\n", -1)
+	code = strings.TrimLeft(code, "\n")
+	code = strings.TrimRight(code, " \t\n")
+	writeCodeBlock(w, lang, []byte(code+"\n"))
+	return nil
+}
+
+// Handles the "[gallery]" shortcode. It's written as
+//
+//	{% gallery columns=3 %}
+//	![alt](image)
+//	...
+//	{% endgallery %}
+//
+// with one image per line.
+func handleGallery(w *writer, node *html.Node) {
+	ids := parseIdList(attr(node, "ids"))
+	if len(ids) == 0 {
+		ids = parseIdList(attr(node, "include")) // before Wordpress 3.5
+	}
+	atts := w.RewriteUrl.GalleryAttachments(ids, parseIdList(attr(node, "exclude")))
+	if len(atts) == 0 {
+		return
+	}
+
+	columns := attr(node, "columns")
+	if _, err := strconv.Atoi(columns); err != nil {
+		columns = "3" // Wordpress default
+	}
+
+	w.EnsureLinefeeds(2)
+	w.WriteString("{% gallery columns=" + columns + " %}")
+	for _, att := range atts {
+		w.EnsureLinefeeds(1)
+		url := w.RewriteUrl.UrlRewrite(att.Url, att.Alt)
+		surround(w, "![", []byte(att.Alt), "]", "[]")
+		surround(w, "(", []byte(url), ")", "()")
+	}
+	w.EnsureLinefeeds(1)
+	w.WriteString("{% endgallery %}")
+	w.EnsureLinefeeds(2)
+}
+
+// Parses a comma-separated list of post ids, skipping invalid ones.
+func parseIdList(list string) []int {
+	var ids []int
+	for _, field := range strings.Split(list, ",") {
+		if id, err := strconv.Atoi(strings.TrimSpace(field)); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// Renders a simple table as a GFM pipe table: no row or column spans,
+// inline-only cell contents. The first row becomes the header. Returns false
+// if the table isn't simple.
+func handleTable(w *writer, node *html.Node) (bool, error) {
+	rows := tableRows(node)
+	if rows == nil {
+		return false, nil
+	}
+
+	numCols := 0
+	for _, row := range rows {
+		if len(row) > numCols {
+			numCols = len(row)
+		}
+	}
+
+	// render all cells first, so nothing is written if one of them
+	// turns out not to fit in a pipe table after all.
+	cells := make([][]string, len(rows))
+	align := make([]string, numCols)
+	for i, row := range rows {
+		cells[i] = make([]string, numCols)
+		for j, cell := range row {
+			wr := w.Clone()
+			if err := renderContents(wr, "", cell, ""); err != nil {
+				return false, err
+			}
+			text, ok := tableCellText(wr.String())
+			if !ok {
+				return false, nil
+			}
+			cells[i][j] = text
+			if align[j] == "" {
+				align[j] = cellAlignment(cell)
+			}
+		}
+	}
+
+	// The line feeds between rows aren't verbatim, so a table in a list
+	// or quote gets the indent on every row.
+	w.EnsureLinefeeds(2)
+	for i, row := range cells {
+		w.WriteString("|")
+		for _, text := range row {
+			w.Verbatim++
+			w.WriteString(" " + text + " |")
+			w.Verbatim--
+		}
+		w.WriteString("\n")
+
+		if i == 0 {
+			w.WriteString("|")
+			for _, a := range align {
+				switch a {
+				case "left":
+					w.WriteString(" :--- |")
+				case "center":
+					w.WriteString(" :---: |")
+				case "right":
+					w.WriteString(" ---: |")
+				default:
+					w.WriteString(" --- |")
+				}
+			}
+			w.WriteString("\n")
+		}
+	}
+	w.EnsureLinefeeds(2)
+	return true, nil
+}
+
+// Returns the cells of a simple table by row, or nil if the table has
+// captions, spans, non-inline cell contents or anything else that doesn't
+// map onto a pipe table.
+func tableRows(table *html.Node) [][]*html.Node {
+	var rows [][]*html.Node
+	var addRows func(parent *html.Node) bool
+	addRows = func(parent *html.Node) bool {
+		for n := parent.FirstChild; n != nil; n = n.NextSibling {
+			if n.Type == html.TextNode && strings.TrimSpace(n.Data) == "" {
+				continue
+			} else if n.Type != html.ElementNode {
+				return false
+			}
+
+			switch n.DataAtom {
+			case atom.Thead, atom.Tbody, atom.Tfoot:
+				if parent != table || !addRows(n) {
+					return false
+				}
+			case atom.Tr:
+				row := tableRow(n)
+				if row == nil {
+					return false
+				}
+				rows = append(rows, row)
+			default:
+				return false
+			}
+		}
+		return true
+	}
+
+	if !addRows(table) || len(rows) == 0 {
+		return nil
+	}
+	return rows
+}
+
+func tableRow(tr *html.Node) []*html.Node {
+	var cells []*html.Node
+	for n := tr.FirstChild; n != nil; n = n.NextSibling {
+		if n.Type == html.TextNode && strings.TrimSpace(n.Data) == "" {
+			continue
+		}
+		if n.Type != html.ElementNode || (n.DataAtom != atom.Td && n.DataAtom != atom.Th) {
+			return nil
+		}
+		if span := attr(n, "rowspan"); span != "" && span != "1" {
+			return nil
+		}
+		if span := attr(n, "colspan"); span != "" && span != "1" {
+			return nil
+		}
+		if !isInlineOnly(n) {
+			return nil
+		}
+		cells = append(cells, n)
+	}
+	return cells
+}
+
+// Inline elements that can go in a pipe table cell.
+var inlineElements = map[atom.Atom]bool{
+	atom.A: true, atom.Abbr: true, atom.B: true, atom.Br: true,
+	atom.Code: true, atom.Del: true, atom.Em: true, atom.I: true,
+	atom.Img: true, atom.Ins: true, atom.Small: true, atom.Span: true,
+	atom.Strike: true, atom.Strong: true, atom.Sub: true, atom.Sup: true,
+}
+
+func isInlineOnly(node *html.Node) bool {
+	for n := node.FirstChild; n != nil; n = n.NextSibling {
+		switch n.Type {
+		case html.TextNode:
+			if strings.Contains(n.Data, "\n\n") {
+				return false // paragraph break
+			}
+		case html.ElementNode:
+			if n.Namespace == shortcode.Namespace {
+				if n.Data != "latex" {
+					return false
+				}
+			} else if !inlineElements[n.DataAtom] {
+				return false
+			}
+			if !isInlineOnly(n) {
+				return false
+			}
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// Turns the Markdown for a cell into a single line. Fails if the cell
+// contains a "|" that would end the cell early.
+func tableCellText(md string) (string, bool) {
+	text := strings.TrimSpace(md)
+	text = strings.TrimSpace(strings.TrimPrefix(text, "<br>"))
+	text = strings.TrimSpace(strings.TrimSuffix(text, "<br>"))
+	text = strings.Join(strings.Fields(text), " ")
+
+	for i := 0; i < len(text); i++ {
+		if text[i] == '\\' {
+			i++ // skip escaped char
+		} else if text[i] == '|' {
+			return "", false
+		}
+	}
+	return text, true
+}
+
+// Returns the alignment of a table cell, from either the "align" attribute
+// or a "text-align" style, or "" if it has none.
+func cellAlignment(cell *html.Node) string {
+	align := strings.ToLower(attr(cell, "align"))
+	for _, decl := range strings.Split(attr(cell, "style"), ";") {
+		if kv := strings.SplitN(decl, ":", 2); len(kv) == 2 && strings.TrimSpace(strings.ToLower(kv[0])) == "text-align" {
+			align = strings.ToLower(strings.TrimSpace(kv[1]))
+		}
+	}
+	switch align {
+	case "left", "center", "right":
+		return align
+	}
+	return ""
+}
+
 func handleWpCaption(w *writer, node *html.Node) error {
 	if err := checkWpCaption(node); err != nil {
 		return err
@@ -470,7 +930,14 @@ func handleWpCaption(w *writer, node *html.Node) error {
 	// TODO handle other attributes!
 	w.WriteString("{% figure %}")
 	for n := node.FirstChild; n != renderEnd; n = n.NextSibling {
-		if err := renderContents(w, "", n, ""); err != nil {
+		// links around the image get dropped, the image itself stays.
+		var err error
+		if n.Type == html.ElementNode && n.DataAtom == atom.Img {
+			err = renderElement(w, n, -1)
+		} else {
+			err = renderContents(w, "", n, "")
+		}
+		if err != nil {
 			return err
 		}
 	}
@@ -495,6 +962,8 @@ func checkWpCaption(node *html.Node) error {
 }
 
 var (
+	// srcset and sizes only list resized versions of the image, we
+	// don't need them in the Markdown output.
 	imgAllowedAttrs = map[string]bool{
 		"src":    true,
 		"alt":    true,
@@ -503,6 +972,8 @@ var (
 		"height": true,
 		"class":  true,
 		"style":  true,
+		"srcset": true,
+		"sizes":  true,
 	}
 	hasFloatLeft  = regexp.MustCompile(`(\W|^)float:\s*left\s*(;|$)`)
 	hasFloatRight = regexp.MustCompile(`(\W|^)float:\s*right\s(;|$)`)
@@ -517,7 +988,7 @@ func handleImage(w *writer, node *html.Node) bool {
 	alt := attr(node, "alt")
 	title := attr(node, "title")
 
-	url = w.RewriteUrl.UrlRewrite(url)
+	url = w.RewriteUrl.UrlRewrite(url, alt)
 
 	// TODO look at class for alignment
 	out_attrs := ""
@@ -574,6 +1045,22 @@ func containsOnlyListItems(node *html.Node) bool {
 	return true
 }
 
+// Concatenates all text inside a node, ignoring markup.
+func textContent(node *html.Node) string {
+	var buf bytes.Buffer
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.TextNode {
+			buf.WriteString(n.Data)
+		}
+		for kid := n.FirstChild; kid != nil; kid = kid.NextSibling {
+			walk(kid)
+		}
+	}
+	walk(node)
+	return buf.String()
+}
+
 // Gets the child text, but only if the node doesn't contain any other nodes
 // or attributes.
 func tryLeafChildText(node *html.Node) []byte {
@@ -681,6 +1168,20 @@ func isBlockLevelElement(node *html.Node) bool {
 	return false
 }
 
+// The "more" and "nextpage" markers and the block editor's delimiter
+// comments separate blocks, too.
+func isBlockComment(node *html.Node) bool {
+	if node.Type != html.CommentNode {
+		return false
+	}
+	data := strings.TrimSpace(node.Data)
+	return isMoreComment(node) || node.Data == "nextpage" || strings.HasPrefix(data, "wp:") || strings.HasPrefix(data, "/wp:")
+}
+
+func isBlockBoundary(node *html.Node) bool {
+	return isBlockLevelElement(node) || isBlockComment(node)
+}
+
 func isPrevBlockBoundary(node *html.Node) bool {
 	n := node
 
@@ -688,7 +1189,7 @@ func isPrevBlockBoundary(node *html.Node) bool {
 	// if we run into block-level elements along the way,
 	// we've crossed a boundary!
 	for {
-		if isBlockLevelElement(n) {
+		if isBlockBoundary(n) {
 			return true
 		}
 		if n.PrevSibling != nil {
@@ -704,7 +1205,7 @@ func isPrevBlockBoundary(node *html.Node) bool {
 	// walk down to the leaves and check again if we run
 	// into block-level elements.
 	for n != nil {
-		if isBlockLevelElement(n) {
+		if isBlockBoundary(n) {
 			return true
 		}
 		n = n.LastChild
@@ -718,7 +1219,7 @@ func isNextBlockBoundary(node *html.Node) bool {
 
 	// dual to the above function
 	for {
-		if isBlockLevelElement(n) {
+		if isBlockBoundary(n) {
 			return true
 		}
 		if n.NextSibling != nil {
@@ -732,7 +1233,7 @@ func isNextBlockBoundary(node *html.Node) bool {
 	}
 
 	for n != nil {
-		if isBlockLevelElement(n) {
+		if isBlockBoundary(n) {
 			return true
 		}
 		n = n.FirstChild
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+// Leaves URLs alone and knows no attachments.
+type stubRewriter struct{}
+
+func (stubRewriter) UrlRewrite(url, text string) string {
+	return url
+}
+
+func (stubRewriter) GalleryAttachments(ids, exclude []int) []*Attachment {
+	return nil
+}
+
+// Expected output is compared without the surrounding line feeds.
+type conversionTest struct {
+	html, want string
+}
+
+func testConversions(t *testing.T, tests []conversionTest) {
+	for _, test := range tests {
+		got, err := ConvertHtmlToMarkdown([]byte(test.html), stubRewriter{})
+		if err != nil {
+			t.Errorf("%q: conversion error: %s", test.html, err.Error())
+		} else if got = bytes.Trim(got, "\n"); string(got) != test.want {
+			t.Errorf("%q: want %q but got %q", test.html, test.want, string(got))
+		}
+	}
+}
+
+func TestTables(t *testing.T) {
+	testConversions(t, []conversionTest{
+		{"<table><tr><th>a</th><th>b</th></tr><tr><td>1</td><td>2</td></tr></table>",
+			"| a | b |\n| --- | --- |\n| 1 | 2 |"},
+		{"<table>\n<thead>\n<tr><th>a</th></tr>\n</thead>\n<tbody>\n<tr><td>1</td></tr>\n</tbody>\n</table>",
+			"| a |\n| --- |\n| 1 |"},
+		{"<table><tr><td>a</td><td>b</td><td>c</td></tr><tr><td>1</td></tr></table>",
+			"| a | b | c |\n| --- | --- | --- |\n| 1 |  |  |"},
+		{`<table><tr><th align="left">a</th><th style="text-align: center">b</th><th align="RIGHT">c</th></tr></table>`,
+			"| a | b | c |\n| :--- | :---: | ---: |"},
+		{`<table><tr><th>a</th><th>b</th></tr><tr><td>1</td><td align="right">2</td></tr></table>`,
+			"| a | b |\n| --- | ---: |\n| 1 | 2 |"},
+		{"<table><tr><td>a|b</td></tr></table>",
+			"| a\\|b |\n| --- |"},
+		{"<table><tr><td><em>a</em> <code>b</code></td><td>x<br>y</td><td><br>z<br></td></tr></table>",
+			"| *a* `b` | x<br> y | z |\n| --- | --- | --- |"},
+		{"<table><tr><td><span>a  \n  b</span></td></tr></table>",
+			"| <span>a b</span> |\n| --- |"},
+		{"<p>before</p><table><tr><td>a</td></tr></table><p>after</p>",
+			"before\n\n| a |\n| --- |\n\nafter"},
+		{"<blockquote><table><tr><th>a</th><th>b</th></tr><tr><td>1</td><td>2</td></tr></table></blockquote>",
+			"> \n> \n> | a | b |\n> | --- | --- |\n> | 1 | 2 |\n> "},
+		{"<blockquote><p>q</p><table><tr><td>a</td></tr></table></blockquote>",
+			"> \n> \n> q\n> \n> | a |\n> | --- |\n> "},
+		{"<ul><li>x<table><tr><td>a</td></tr></table></li></ul>",
+			"* x\n    \n    | a |\n    | --- |\n    "},
+
+		// not simple: kept as HTML
+		{"<table><tr><td><code>a|b</code></td></tr></table>",
+			"<table><tbody><tr><td><code>a|b</code></td></tr></tbody></table>"},
+		{`<table><tr><td colspan="2">a</td></tr></table>`,
+			`<table><tbody><tr><td colspan="2">a</td></tr></tbody></table>`},
+		{"<table><tr><td><p>a</p></td></tr></table>",
+			"<table><tbody><tr><td><p>a</p></td></tr></tbody></table>"},
+		{"<table><caption>c</caption><tr><td>a</td></tr></table>",
+			"<table><caption>c</caption><tbody><tr><td>a</td></tr></tbody></table>"},
+		{"<table></table>", "<table></table>"},
+	})
+}
+
+func TestCode(t *testing.T) {
+	testConversions(t, []conversionTest{
+		// code spans
+		{"<code>a*b</code>", "`a*b`"},
+		{"<code>a`b</code>", "``a`b``"},
+		{"<code>a``b`c</code>", "```a``b`c```"},
+		{"<code>`a</code>", "`` `a ``"},
+		{"<code>a`</code>", "`` a` ``"},
+		{"<code> a </code>", "`  a  `"},
+		{"<code> a</code>", "` a`"},
+		{"<code>  </code>", "`  `"},
+
+		// pre blocks
+		{"<pre>int x;\nint y;</pre>", "```\nint x;\nint y;\n```"},
+		{"<pre>x = {{1}};</pre>", "```\nx = {{1}};\n```"},
+		{"<pre>a ``` b</pre>", "````\na ``` b\n````"},
+		{"<pre>\tindented</pre>", "```\n        indented\n```"},
+		{`<pre class="brush: cpp; gutter: false">if (a &lt; b) f();</pre>`, "```cpp\nif (a < b) f();\n```"},
+		{`<pre lang="Python">pass</pre>`, "```python\npass\n```"},
+		{`<pre><code class="language-go">x := 1</code></pre>`, "```go\nx := 1\n```"},
+		{`<pre><span class="kw">int</span> x;<br>int y;</pre>`, "```\nint x;\nint y;\n```"},
+		{`<pre><div class="line">a</div><div class="line">b</div></pre>`, "```\na\nb\n```"},
+		{`<pre>a <strong>*b*</strong> c</pre>`, "```\na *b* c\n```"},
+		{"<pre>$latex x^2$</pre>", "```\n$latex x^2$\n```"},
+
+		// code shortcodes
+		{"[sourcecode language=\"cpp\"]\nif (a < b && c) {}\n[/sourcecode]", "```cpp\nif (a < b && c) {}\n```"},
+		{"[code lang=\"C++\"]<b>x</b>[/code]", "```c++\n<b>x</b>\n```"},
+		{"[sourcecode cpp]\r\n\r\n  x;  \r\n\r\n[/sourcecode]", "```cpp\n  x;\n```"},
+	})
+}
+
+func TestEmbeds(t *testing.T) {
+	testConversions(t, []conversionTest{
+		// auto-embeds
+		{"https://www.youtube.com/watch?v=abc-_1", "{% embed youtube id=abc-_1 %}"},
+		{"<p>https://youtu.be/abc</p>", "{% embed youtube id=abc %}"},
+		{"Look:\nhttps://vimeo.com/123\nNice.", "Look:\n\n{% embed vimeo id=123 %}\n\nNice."},
+		{"Look:<br>\n  https://vimeo.com/123  <br>\nNice.", "Look:\n\n{% embed vimeo id=123 %}\n\nNice."},
+		{"<p>a\nhttps://youtu.be/a\n\nhttps://youtu.be/b\nb</p>", "a\n\n{% embed youtube id=a %}\n\n{% embed youtube id=b %}\n\nb"},
+		{"Look:<br>https://youtu.be/abc<br>Nice.", "Look:\n\n{% embed youtube id=abc %}\n\nNice."},
+		{"[embed]https://youtu.be/a[/embed]\nhttps://youtu.be/b", "{% embed youtube id=a %}\n\n{% embed youtube id=b %}"},
+		{"https://twitter.com/someone/status/123", "{% embed twitter user=someone id=123 %}"},
+		{"<p><em>x</em>\nhttps://youtu.be/abc</p>", "*x*\n\n{% embed youtube id=abc %}"},
+
+		// not on a line of their own
+		{"See https://youtu.be/abc here.", "See https\\://youtu.be/abc here."},
+		{"<p>See https://youtu.be/abc</p>", "See https\\://youtu.be/abc"},
+		{"<p><em>x</em>https://youtu.be/abc</p>", "*x*https\\://youtu.be/abc"},
+		{`<a href="https://youtu.be/abc">https://youtu.be/abc</a>`, "[https://youtu.be/abc](https://youtu.be/abc)"},
+		{"https://example.com/video", "https\\://example.com/video"},
+
+		// shortcodes
+		{"[embed]https://youtu.be/abc[/embed]", "{% embed youtube id=abc %}"},
+		{"[embed width=\"400\"] https://vimeo.com/channels/staff/123 [/embed]", "{% embed vimeo id=123 %}"},
+		{"[youtube=https://www.youtube.com/watch?v=abc&w=640]", "{% embed youtube id=abc %}"},
+		{"[youtube https://youtu.be/abc]", "{% embed youtube id=abc %}"},
+		{"[youtube abc]", "{% embed youtube id=abc %}"},
+		{"[vimeo 123]", "{% embed vimeo id=123 %}"},
+		{`[vimeo id="123"]`, "{% embed vimeo id=123 %}"},
+		{"[tweet https://x.com/someone/status/123]", "{% embed twitter user=someone id=123 %}"},
+		{"[embed]https://example.com/video[/embed]", "<https://example.com/video>"},
+		{"[tweet 123456]", "{% embed twitter user=i id=123456 %}"},
+		{"[tweet id=123456]", "{% embed twitter user=i id=123456 %}"},
+		{"[tweet abc]", "abc"},
+		{"[embed]not a url[/embed]", "not a url"},
+		{"[embed]https://example.com/a b[/embed]", "https\\://example.com/a b"},
+		{"[embed][/embed]", ""},
+
+		// players
+		{`<iframe src="//www.youtube.com/embed/abc?rel=0" width="560"></iframe>`, "{% embed youtube id=abc %}"},
+		{`<iframe src="https://player.vimeo.com/video/123"></iframe>`, "{% embed vimeo id=123 %}"},
+		{`<iframe src="https://example.com/player"></iframe>`, `<iframe src="https://example.com/player"></iframe>`},
+	})
+}
+
+func TestMarkers(t *testing.T) {
+	testConversions(t, []conversionTest{
+		{"Intro.<!--more-->Rest.", "Intro.\n\n<!--more-->\n\nRest."},
+		{"Intro.\n<!--more-->\nRest.", "Intro.\n\n<!--more-->\n\nRest."},
+		{"Intro.\n\n<!--more Keep reading-->\n\nRest.", "Intro.\n\n<!--more-->\n\nRest."},
+		{"<!-- wp:paragraph -->\n<p>Intro.</p>\n<!-- /wp:paragraph -->\n\n<!-- wp:more -->\n<!--more-->\n<!-- /wp:more -->\n\n<!-- wp:paragraph -->\n<p>Rest.</p>\n<!-- /wp:paragraph -->",
+			"Intro.\n\n<!--more-->\n\nRest."},
+		{"One\n<!--nextpage-->\nTwo", "One\n\n<hr id=\"page-2\">\n\nTwo"},
+		{"One<!-- nextpage -->Two", "OneTwo"},
+		{"Intro.\n<!-- wp:more -->\n<!--more-->\n<!-- /wp:more -->\nRest.", "Intro.\n\n<!--more-->\n\nRest."},
+	})
+}
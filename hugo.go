@@ -0,0 +1,266 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"io"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Writes a Hugo site: posts go to "content/posts/<slug>.md", pages to
+// "content/<path>.md" and media to "static/". Pages with children are
+// sections in Hugo, so they go to "content/<path>/_index.md". Comments go
+// to "data/comments/<path>.yaml".
+type hugoBackend struct {
+	opts        *Options
+	hasChildren map[*Doc]bool // exported docs with exported children; built on first use
+}
+
+func newHugoBackend(opts *Options) Backend {
+	return &hugoBackend{opts: opts}
+}
+
+func (h *hugoBackend) DocLink(doc *Doc) string {
+	if doc.Type == DocPost {
+		return "/posts/" + doc.Path() + "/"
+	}
+	return "/" + doc.Path() + "/"
+}
+
+func (h *hugoBackend) MediaDir() string {
+	return "static/" + h.opts.MediaPath
+}
+
+func (h *hugoBackend) MediaLink(filename string) string {
+	return "/" + h.opts.MediaPath + "/" + filename
+}
+
+func (h *hugoBackend) WriteDoc(blog *Blog, doc *Doc, dest string) error {
+	if h.hasChildren == nil {
+		h.hasChildren = make(map[*Doc]bool)
+		for _, d := range blog.Docs {
+			if d.Parent != nil && h.opts.Statuses[d.Status] {
+				h.hasChildren[d.Parent] = true
+			}
+		}
+	}
+
+	fname := "content/" + doc.Path() + ".md"
+	if doc.Type == DocPost {
+		fname = "content/posts/" + doc.Path() + ".md"
+	} else if h.hasChildren[doc] {
+		fname = "content/" + doc.Path() + "/_index.md"
+	}
+	err := writeFile(filepath.Join(dest, filepath.FromSlash(fname)), func(wr io.Writer) error {
+		return h.writeDoc(wr, doc)
+	})
+	if err != nil {
+		return err
+	}
+
+	if len(doc.Comments) != 0 {
+		err = writeFile(filepath.Join(dest, "data", "comments", filepath.FromSlash(doc.Path())+".yaml"), func(wr io.Writer) error {
+			return writeCommentData(wr, doc.Comments)
+		})
+	}
+	return err
+}
+
+func (h *hugoBackend) writeDoc(wr io.Writer, doc *Doc) error {
+	fm := frontMatter{toml: h.opts.FrontMatter == "toml"}
+	fm.Add("title", doc.Title)
+	fm.Add("date", doc.PublishedDate)
+	// Hugo holds back future posts by itself
+	fm.Add("draft", doc.Status != StatusPublish && doc.Status != StatusFuture)
+	fm.Add("slug", doc.Id)
+	var aliases []string
+	for _, link := range append([]string{doc.Link}, doc.Aliases...) {
+		if alias := permalinkPath(link); alias != "" && alias != h.DocLink(doc) {
+			aliases = append(aliases, alias)
+		}
+	}
+	fm.AddList("aliases", aliases)
+	if doc.Author != nil {
+		fm.Add("author", doc.Author.Name)
+	}
+	fm.AddList("categories", categoryNames(doc))
+	fm.AddList("tags", tagNames(doc))
+	if len(doc.Excerpt) != 0 {
+		// Hugo renders the summary as Markdown, but doesn't expand
+		// shortcodes in it.
+		summary := replaceFigures(doc.Excerpt, func(fig *figure) string { return figureHtml(fig, "") })
+		summary = replaceGalleries(summary, galleryHtml)
+		summary = replaceEmbeds(summary, embedHtml)
+		fm.Add("summary", string(summary))
+	}
+
+	if _, err := fm.WriteTo(wr); err != nil {
+		return err
+	}
+
+	content := replaceFigures(doc.Content, func(fig *figure) string {
+		var params []string
+		for _, p := range []struct{ key, val string }{
+			{"src", fig.Src},
+			{"alt", fig.Alt},
+			{"title", fig.Title},
+			{"class", fig.Class},
+			{"caption", fig.Caption},
+		} {
+			if p.val != "" {
+				params = append(params, fmt.Sprintf("%s=\"%s\"", p.key, shortcodeParamEscaper.Replace(p.val)))
+			}
+		}
+		return "{{< figure " + strings.Join(params, " ") + " >}}"
+	})
+	content = replaceGalleries(content, galleryHtml)
+	content = replaceEmbeds(content, func(e *embed) string {
+		// all of these are built into Hugo
+		if e.Provider == "twitter" {
+			return fmt.Sprintf(`{{< tweet user="%s" id="%s" >}}`, e.User, e.Id)
+		}
+		return "{{< " + e.Provider + " " + e.Id + " >}}"
+	})
+	_, err := wr.Write(content)
+	return err
+}
+
+// Escapes quoted Hugo shortcode params. Hugo HTML-escapes the values by
+// itself, so entities would show up literally.
+var shortcodeParamEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+
+// Returns the path of a permalink on the Wordpress site, with a trailing
+// slash, or "" if it isn't a "pretty" permalink.
+func permalinkPath(link string) string {
+	parsed, err := url.Parse(link)
+	if err != nil || parsed.Path == "" || parsed.RawQuery != "" {
+		return ""
+	}
+	p := parsed.Path
+	if !strings.HasSuffix(p, "/") {
+		p += "/"
+	}
+	return p
+}
+
+func categoryNames(doc *Doc) []string {
+	var names []string
+	for _, cat := range doc.Categories {
+		names = append(names, cat.Name)
+	}
+	return names
+}
+
+func tagNames(doc *Doc) []string {
+	var names []string
+	for _, tag := range doc.Tags {
+		names = append(names, tag.Name)
+	}
+	return names
+}
+
+// An image with a caption, as written by handleWpCaption.
+type figure struct {
+	Src     string
+	Alt     string
+	Title   string
+	Class   string // from the "{floatleft}" etc. markers handleImage puts in the alt text
+	Caption string // Markdown
+}
+
+var (
+	figureRe = regexp.MustCompile(`(?s)\{% figure %\}(.*?)\{% figcaption %\}(.*?)\{% endfigcaption %\}\{% endfigure %\}`)
+
+	// The Markdown image syntax as written by handleImage, with optional title.
+	markdownImageRe = regexp.MustCompile(`^!\[((?:[^\\\]]|\\.)*)\]\(((?:[^\\() ]|\\.)*)(?: "((?:[^\\"]|\\.)*)")?\)$`)
+	imageClassRe    = regexp.MustCompile(`^\{([^}]*)\}`)
+	backslashEscRe  = regexp.MustCompile(`\\(.)`)
+)
+
+// Replaces the "{% figure %}" blocks in content with the result of
+// render. Figures that don't contain a plain Markdown image are turned into
+// HTML figure elements instead.
+func replaceFigures(content []byte, render func(fig *figure) string) []byte {
+	return figureRe.ReplaceAllFunc(content, func(match []byte) []byte {
+		m := figureRe.FindSubmatch(match)
+		inner, caption := strings.TrimSpace(string(m[1])), string(m[2])
+
+		img := markdownImageRe.FindStringSubmatch(inner)
+		if img == nil {
+			return []byte("<figure>" + inner + "<figcaption>" + caption + "</figcaption></figure>")
+		}
+
+		fig := &figure{
+			Alt:     unescapeMarkdown(img[1]),
+			Src:     unescapeMarkdown(img[2]),
+			Title:   unescapeMarkdown(img[3]),
+			Caption: caption,
+		}
+		if cls := imageClassRe.FindStringSubmatch(fig.Alt); cls != nil {
+			fig.Class = cls[1]
+			fig.Alt = fig.Alt[len(cls[0]):]
+		}
+		return []byte(render(fig))
+	})
+}
+
+// A gallery, as written by handleGallery.
+type gallery struct {
+	Columns string
+	Images  []*figure // without captions
+}
+
+var galleryRe = regexp.MustCompile(`(?s)\{% gallery columns=(\d+) %\}(.*?)\{% endgallery %\}`)
+
+// Replaces the "{% gallery %}" blocks in content with the result of render.
+func replaceGalleries(content []byte, render func(g *gallery) string) []byte {
+	return galleryRe.ReplaceAllFunc(content, func(match []byte) []byte {
+		m := galleryRe.FindSubmatch(match)
+		g := &gallery{Columns: string(m[1])}
+		for _, line := range strings.Split(string(m[2]), "\n") {
+			if img := markdownImageRe.FindStringSubmatch(strings.TrimSpace(line)); img != nil {
+				g.Images = append(g.Images, &figure{
+					Alt:   unescapeMarkdown(img[1]),
+					Src:   unescapeMarkdown(img[2]),
+					Title: unescapeMarkdown(img[3]),
+				})
+			}
+		}
+		return []byte(render(g))
+	})
+}
+
+// Renders a gallery as HTML, using the same classes as Wordpress so
+// existing theme styles can be reused.
+func galleryHtml(g *gallery) string {
+	var b strings.Builder
+	b.WriteString(`<div class="gallery gallery-columns-` + g.Columns + `">`)
+	for _, img := range g.Images {
+		b.WriteString("\n" + `<figure class="gallery-item"><a href="` + html.EscapeString(img.Src) + `"><img src="` + html.EscapeString(img.Src) + `" alt="` + html.EscapeString(img.Alt) + `"></a></figure>`)
+	}
+	b.WriteString("\n</div>")
+	return b.String()
+}
+
+// Renders a figure as an HTML figure element. captionAttrs go on the
+// figcaption element.
+func figureHtml(fig *figure, captionAttrs string) string {
+	var b strings.Builder
+	b.WriteString("<figure")
+	if fig.Class != "" {
+		b.WriteString(` class="` + html.EscapeString(fig.Class) + `"`)
+	}
+	b.WriteString(`><img src="` + html.EscapeString(fig.Src) + `" alt="` + html.EscapeString(fig.Alt) + `"`)
+	if fig.Title != "" {
+		b.WriteString(` title="` + html.EscapeString(fig.Title) + `"`)
+	}
+	b.WriteString(`><figcaption` + captionAttrs + `>` + fig.Caption + "</figcaption></figure>")
+	return b.String()
+}
+
+func unescapeMarkdown(s string) string {
+	return backslashEscRe.ReplaceAllString(s, "$1")
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestHugoFigure(t *testing.T) {
+	tests := []struct {
+		content, want string
+	}{
+		{`{% figure %}![a "b"](/img.jpg "c\\d"){% figcaption %}Say "hi"{% endfigcaption %}{% endfigure %}`,
+			`{{< figure src="/img.jpg" alt="a \"b\"" title="c\\d" caption="Say \"hi\"" >}}`},
+		{`{% figure %}![{floatleft}x](/img.jpg){% figcaption %}a & b{% endfigcaption %}{% endfigure %}`,
+			`{{< figure src="/img.jpg" alt="x" class="floatleft" caption="a & b" >}}`},
+	}
+	h := newHugoBackend(&Options{}).(*hugoBackend)
+	for _, test := range tests {
+		var buf bytes.Buffer
+		doc := &Doc{Id: "x", Content: []byte(test.content)}
+		if err := h.writeDoc(&buf, doc); err != nil {
+			t.Fatalf("%q: %s", test.content, err.Error())
+		}
+		got := buf.String()
+		got = got[strings.LastIndex(got, "---\n")+4:]
+		if got != test.want {
+			t.Errorf("%q: want %q but got %q", test.content, test.want, got)
+		}
+	}
+}
+
+func TestHugoPagePaths(t *testing.T) {
+	about := &Doc{Id: "about", Type: DocPage, Status: StatusPublish, Comments: []*Comment{{Id: 1}}}
+	team := &Doc{Id: "team", Type: DocPage, Parent: about, Status: StatusPublish}
+	contact := &Doc{Id: "contact", Type: DocPage, Status: StatusPublish}
+	draft := &Doc{Id: "draft", Type: DocPage, Parent: contact, Status: StatusDraft}
+	post := &Doc{Id: "first", Type: DocPost, Status: StatusPublish}
+	part := &Doc{Id: "page-2", Type: DocPost, Parent: post, Status: StatusPublish}
+	blog := &Blog{Docs: []*Doc{about, team, contact, draft, post, part}}
+
+	dest := t.TempDir()
+	h := newHugoBackend(&Options{Statuses: map[DocStatus]bool{StatusPublish: true}})
+	for _, doc := range blog.Docs {
+		if doc.Status != StatusPublish {
+			continue
+		}
+		if err := h.WriteDoc(blog, doc, dest); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, fname := range []string{
+		"content/about/_index.md",
+		"content/about/team.md",
+		"content/contact.md",
+		"content/posts/first.md",
+		"content/posts/first/page-2.md",
+		"data/comments/about.yaml",
+	} {
+		if _, err := os.Stat(filepath.Join(dest, fname)); err != nil {
+			t.Errorf("%s not written: %s", fname, err.Error())
+		}
+	}
+	for _, fname := range []string{"content/about.md", "content/contact/_index.md", "content/contact/draft.md", "data/comments/contact.yaml"} {
+		if _, err := os.Stat(filepath.Join(dest, fname)); err == nil {
+			t.Errorf("%s shouldn't exist", fname)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"io"
+	"path/filepath"
+	"strings"
+)
+
+// Writes a Jekyll site: posts go to "_posts/YYYY-MM-DD-<slug>.md" (or
+// "_drafts/<slug>.md" if unpublished), pages to "<path>.md" and media to
+// "assets/". Docs keep their Wordpress permalinks, and old URLs are listed
+// for the jekyll-redirect-from plugin. Comments go to
+// "_data/comments/<path>.yml".
+type jekyllBackend struct {
+	opts *Options
+}
+
+func newJekyllBackend(opts *Options) Backend {
+	return &jekyllBackend{opts: opts}
+}
+
+func (j *jekyllBackend) DocLink(doc *Doc) string {
+	if p := permalinkPath(doc.Link); p != "" {
+		return p
+	}
+	if doc.Type == DocPost {
+		return "/" + doc.PublishedDate.Format("2006/01/02") + "/" + doc.Path() + "/"
+	}
+	return "/" + doc.Path() + "/"
+}
+
+func (j *jekyllBackend) MediaDir() string {
+	return "assets/" + j.opts.MediaPath
+}
+
+func (j *jekyllBackend) MediaLink(filename string) string {
+	return "/assets/" + j.opts.MediaPath + "/" + filename
+}
+
+func (j *jekyllBackend) WriteDoc(blog *Blog, doc *Doc, dest string) error {
+	fname := doc.Path() + ".md"
+	if doc.Type == DocPost {
+		// posts directories are flat; only the pages of split posts
+		// have parents.
+		slug := strings.Replace(doc.Path(), "/", "-", -1)
+		if jekyllDraft(doc) {
+			fname = "_drafts/" + slug + ".md"
+		} else {
+			fname = "_posts/" + doc.PublishedDate.Format("2006-01-02") + "-" + slug + ".md"
+		}
+	}
+	err := writeFile(filepath.Join(dest, filepath.FromSlash(fname)), func(wr io.Writer) error {
+		return j.writeDoc(wr, doc)
+	})
+	if err != nil {
+		return err
+	}
+
+	if len(doc.Comments) != 0 {
+		err = writeFile(filepath.Join(dest, "_data", "comments", filepath.FromSlash(doc.Path())+".yml"), func(wr io.Writer) error {
+			return writeCommentData(wr, doc.Comments)
+		})
+	}
+	return err
+}
+
+func (j *jekyllBackend) writeDoc(wr io.Writer, doc *Doc) error {
+	var fm frontMatter
+	if doc.Type == DocPost {
+		fm.Add("layout", "post")
+	} else {
+		fm.Add("layout", "page")
+	}
+	fm.Add("title", doc.Title)
+	fm.Add("date", doc.PublishedDate)
+	if jekyllDraft(doc) {
+		// Pages can't be drafts, but they can be kept out of the site. The
+		// status tells pending and private posts from plain drafts.
+		if doc.Type == DocPage {
+			fm.Add("published", false)
+		}
+		fm.Add("status", doc.Status.String())
+	}
+	if doc.Author != nil {
+		fm.Add("author", doc.Author.Name)
+	}
+	fm.AddList("categories", categoryNames(doc))
+	fm.AddList("tags", tagNames(doc))
+	if len(doc.Excerpt) != 0 {
+		fm.Add("excerpt", string(j.renderContent(doc.Excerpt)))
+	}
+	permalink := j.DocLink(doc)
+	fm.Add("permalink", permalink)
+	var redirects []string
+	for _, alias := range doc.Aliases {
+		if p := permalinkPath(alias); p != "" && p != permalink {
+			redirects = append(redirects, p)
+		}
+	}
+	fm.AddList("redirect_from", redirects)
+	// The Markdown is the final text; "{{" and "{%" in code aren't Liquid.
+	// Needs Jekyll 4.
+	fm.Add("render_with_liquid", false)
+
+	if _, err := fm.WriteTo(wr); err != nil {
+		return err
+	}
+
+	_, err := wr.Write(j.renderContent(doc.Content))
+	return err
+}
+
+// Replaces the figure, gallery and embed placeholders in Markdown.
+func (j *jekyllBackend) renderContent(content []byte) []byte {
+	// Jekyll has no figure tag; kramdown still parses the caption as
+	// Markdown thanks to the markdown="span" attribute.
+	content = replaceFigures(content, func(fig *figure) string {
+		return figureHtml(fig, ` markdown="span"`)
+	})
+	content = replaceGalleries(content, galleryHtml)
+	return replaceEmbeds(content, embedHtml)
+}
+
+// Jekyll holds back future posts by itself; everything else that isn't
+// published becomes a draft.
+func jekyllDraft(doc *Doc) bool {
+	return doc.Status != StatusPublish && doc.Status != StatusFuture
+}
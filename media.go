@@ -0,0 +1,134 @@
+package main
+
+import (
+	"code.google.com/p/go.net/html"
+	"code.google.com/p/go.net/html/atom"
+	"path"
+	"strings"
+)
+
+// An audio or video player, from either a shortcode or an HTML5 element.
+type mediaElement struct {
+	Tag     string   // "audio" or "video"
+	Sources []string // URLs of alternative encodings
+	Poster  string   // preview image for videos
+	Width   string
+	Height  string
+	Flags   []string // "loop", "autoplay", "muted"
+}
+
+// The source attributes of the "[audio]" and "[video]" shortcodes, by
+// file type.
+var mediaSourceAttrs = map[string][]string{
+	"audio": {"src", "mp3", "m4a", "ogg", "wav", "wma", "flac"},
+	"video": {"src", "mp4", "m4v", "webm", "ogv", "wmv", "flv"},
+}
+
+var mediaTypes = map[string]string{
+	".mp3":  "audio/mpeg",
+	".m4a":  "audio/mp4",
+	".ogg":  "audio/ogg",
+	".oga":  "audio/ogg",
+	".wav":  "audio/wav",
+	".flac": "audio/flac",
+	".wma":  "audio/x-ms-wma",
+	".mp4":  "video/mp4",
+	".m4v":  "video/mp4",
+	".mov":  "video/quicktime",
+	".webm": "video/webm",
+	".ogv":  "video/ogg",
+	".wmv":  "video/x-ms-wmv",
+	".flv":  "video/x-flv",
+}
+
+// Handles the "[audio]" and "[video]" shortcodes.
+func handleMediaShortcode(w *writer, node *html.Node) {
+	m := &mediaElement{Tag: node.Data}
+	for _, key := range mediaSourceAttrs[node.Data] {
+		if src := attr(node, key); src != "" {
+			m.Sources = append(m.Sources, src)
+		}
+	}
+	if src := attr(node, "@0"); src != "" {
+		// Wordpress.com's "[audio url|titles=...]"
+		m.Sources = append(m.Sources, strings.SplitN(src, "|", 2)[0])
+	}
+	m.Poster = attr(node, "poster")
+	m.Width = attr(node, "width")
+	m.Height = attr(node, "height")
+	for _, flag := range []string{"loop", "autoplay", "muted"} {
+		if val := attr(node, flag); val == "on" || val == "1" || val == "true" {
+			m.Flags = append(m.Flags, flag)
+		}
+	}
+	writeMediaElement(w, m)
+}
+
+// Handles "audio" and "video" elements. Fallback content is dropped.
+func handleMediaElement(w *writer, node *html.Node) {
+	m := &mediaElement{Tag: node.Data}
+	if src := attr(node, "src"); src != "" {
+		m.Sources = append(m.Sources, src)
+	}
+	for n := node.FirstChild; n != nil; n = n.NextSibling {
+		if n.Type == html.ElementNode && n.DataAtom == atom.Source {
+			if src := attr(n, "src"); src != "" {
+				m.Sources = append(m.Sources, src)
+			}
+		}
+	}
+	m.Poster = attr(node, "poster")
+	m.Width = attr(node, "width")
+	m.Height = attr(node, "height")
+	for _, flag := range []string{"loop", "autoplay", "muted"} {
+		if hasAttr(node, flag) {
+			m.Flags = append(m.Flags, flag)
+		}
+	}
+	writeMediaElement(w, m)
+}
+
+// Writes a media element as HTML5 markup, with the sources and poster
+// going through the URL rewriter, so the files get fetched like any other
+// attachment.
+func writeMediaElement(w *writer, m *mediaElement) {
+	if len(m.Sources) == 0 {
+		return
+	}
+
+	var b strings.Builder
+	b.WriteString("<" + m.Tag + " controls")
+	for _, flag := range m.Flags {
+		b.WriteString(" " + flag)
+	}
+	if m.Tag == "video" {
+		if m.Width != "" {
+			b.WriteString(` width="` + html.EscapeString(m.Width) + `"`)
+		}
+		if m.Height != "" {
+			b.WriteString(` height="` + html.EscapeString(m.Height) + `"`)
+		}
+		if m.Poster != "" {
+			poster := w.RewriteUrl.UrlRewrite(m.Poster, "")
+			b.WriteString(` poster="` + html.EscapeString(poster) + `"`)
+		}
+	}
+	b.WriteString(">")
+	for _, src := range m.Sources {
+		// the type is from the original URL; the local name may differ
+		typ := mediaTypes[strings.ToLower(path.Ext(strings.SplitN(src, "?", 2)[0]))]
+		src = w.RewriteUrl.UrlRewrite(src, "")
+		b.WriteString(`<source src="` + html.EscapeString(src) + `"`)
+		if typ != "" {
+			b.WriteString(` type="` + typ + `"`)
+		}
+		b.WriteString(">")
+	}
+	b.WriteString("</" + m.Tag + ">")
+
+	w.EnsureLinefeeds(2)
+	w.Verbatim++
+	w.WriteString(b.String())
+	w.Verbatim--
+	w.EnsureLinefeeds(2)
+}
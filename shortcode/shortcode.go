@@ -3,6 +3,8 @@ package shortcode
 import (
 	"code.google.com/p/go.net/html"
 	"fmt"
+	"regexp"
+	"strconv"
 	"strings"
 	"unicode/utf8"
 )
@@ -36,6 +38,101 @@ var shortcodeIsBlock = map[string]bool{
 	"caption":    true,
 	"wp_caption": true,
 	"latex":      true,
+	"sourcecode": true,
+	"source":     true,
+	"code":       true,
+	"gallery":    false,
+	"embed":      true,
+	"youtube":    false,
+	"vimeo":      false,
+	"tweet":      false,
+	"audio":      false,
+	"video":      false,
+}
+
+// Standalone shortcodes that Wordpress' editor writes with a closing tag
+// anyway, as in "[video mp4=...][/video]". The closing tag is dropped.
+var shortcodeOptionalClose = map[string]bool{
+	"audio": true,
+	"video": true,
+}
+
+// Shortcodes whose body is source code. It has to be cut out of the HTML
+// before parsing it, or the parser would mangle "<", "&" and friends.
+var verbatimShortcodes = map[string]bool{
+	"sourcecode": true,
+	"source":     true,
+	"code":       true,
+}
+
+var (
+	verbatimTagRe     = regexp.MustCompile(`\[(\[?)(/?)(sourcecode|source|code)((?:\s[^\]]*?)?)(/?)\]`)
+	verbatimPlaceRe   = regexp.MustCompile("\uE000([0-9]+)\uE000")
+	verbatimPlaceRune = '\uE000' // from the private use area, so it doesn't occur in posts
+)
+
+// Replaces the bodies of verbatim shortcodes (like "[sourcecode]") in HTML
+// source with placeholders that survive HTML parsing, returning the new
+// source and the cut out text. Unmatched verbatim tags are protected the
+// same way, so they stay as literal text. Use RestoreVerbatim on the parse
+// tree after processing shortcodes to put the text back.
+func ExtractVerbatim(src string) (string, []string) {
+	var out strings.Builder
+	var verbatim []string
+	placeholder := func(text string) string {
+		verbatim = append(verbatim, text)
+		return fmt.Sprintf("%c%d%c", verbatimPlaceRune, len(verbatim)-1, verbatimPlaceRune)
+	}
+
+	for {
+		m := verbatimTagRe.FindStringSubmatchIndex(src)
+		if m == nil {
+			break
+		}
+		escaped, closing, selfClosing := m[3] > m[2], m[5] > m[4], m[11] > m[10]
+		tag := src[m[6]:m[7]]
+
+		switch {
+		case escaped || selfClosing:
+			// nothing to protect
+			out.WriteString(src[:m[1]])
+			src = src[m[1]:]
+		case closing:
+			out.WriteString(src[:m[0]] + placeholder("[") + src[m[0]+1:m[1]])
+			src = src[m[1]:]
+		default:
+			closeTag := "[/" + tag + "]"
+			end := strings.Index(src[m[1]:], closeTag)
+			if end == -1 {
+				out.WriteString(src[:m[0]] + placeholder("[") + src[m[0]+1:m[1]])
+				src = src[m[1]:]
+			} else {
+				end += m[1]
+				out.WriteString(src[:m[1]] + placeholder(src[m[1]:end]) + closeTag)
+				src = src[end+len(closeTag):]
+			}
+		}
+	}
+	out.WriteString(src)
+	return out.String(), verbatim
+}
+
+// Puts the text cut out by ExtractVerbatim back into the parse tree.
+func RestoreVerbatim(node *html.Node, verbatim []string) {
+	for n := node.FirstChild; n != nil; n = n.NextSibling {
+		switch n.Type {
+		case html.TextNode:
+			n.Data = verbatimPlaceRe.ReplaceAllStringFunc(n.Data, func(place string) string {
+				i, err := strconv.Atoi(place[utf8.RuneLen(verbatimPlaceRune) : len(place)-utf8.RuneLen(verbatimPlaceRune)])
+				if err != nil || i >= len(verbatim) {
+					return place
+				}
+				return verbatim[i]
+			})
+		case html.ElementNode:
+			RestoreVerbatim(n, verbatim)
+		}
+	}
 }
 
 type openTag struct {
@@ -239,7 +336,8 @@ func parseShortcode(text string) (size, openClose int, tag, rest string) {
 	}
 
 	// is this a closing tag?
-	if pos < len(text) && text[pos] == '/' {
+	closing := pos < len(text) && text[pos] == '/'
+	if closing {
 		openClose |= tagClose
 		pos++
 	} else {
@@ -260,7 +358,12 @@ func parseShortcode(text string) (size, openClose int, tag, rest string) {
 		return
 	} else if !block {
 		// if it's not a block tag, [/tag] makes no sense.
-		if openClose == tagClose {
+		if closing {
+			if shortcodeOptionalClose[tag] && nameend < len(text) && text[nameend] == ']' {
+				size = nameend + 1
+				openClose = 0
+				tag = ""
+			}
 			return
 		}
 		openClose = tagOpen | tagClose
@@ -286,7 +389,7 @@ func parseShortcode(text string) (size, openClose int, tag, rest string) {
 	if text[end-1] == '/' {
 		openClose |= tagClose
 		restend--
-	} else if openClose&tagClose != 0 && nameend != restend {
+	} else if closing && nameend != restend {
 		// Actual closing tags may not have anything but the tag name.
 		return
 	}
@@ -40,6 +40,10 @@ func TestShortcode(t *testing.T) {
 		{"a[[caption]]b", "<body>a[caption]b</body>"},
 		{"a[thistagisnotdefined]b", "<body>a[thistagisnotdefined]b</body>"},
 		{"a[[thistagisnotdefined]]b", "<body>a[[thistagisnotdefined]]b</body>"},
+		{`a[gallery ids="1,2" columns=3]b`, `<body>a<gallery ids="1,2" columns="3"></gallery>b</body>`},
+		{"a[gallery]b[/gallery]c", "<body>a<gallery></gallery>b[/gallery]c</body>"},
+		{`a[video mp4="b.mp4"][/video]c`, `<body>a<video mp4="b.mp4"></video>c</body>`},
+		{"a[/audio]b", "<body>ab</body>"},
 	}
 	for _, test := range tests {
 		tree := parseHtmlBody(test.html, t)
@@ -77,3 +81,30 @@ func TestLatex(t *testing.T) {
 		}
 	}
 }
+
+func TestVerbatim(t *testing.T) {
+	tests := []struct {
+		html, want string
+	}{
+		{"a[code]x < y && <b>[/code]b", "<body>a<code>x &lt; y &amp;&amp; &lt;b&gt;</code>b</body>"},
+		{`a[sourcecode language="cpp"]#include <vector>[/sourcecode]`, `<body>a<sourcecode language="cpp">#include &lt;vector&gt;</sourcecode></body>`},
+		{"<p>[code]a</p><p>b[/code]</p>", "<body><p><code>a&lt;/p&gt;&lt;p&gt;b</code></p></body>"},
+		{"a[code]b[/sourcecode]c", "<body>a[code]b[/sourcecode]c</body>"},
+		{"a[[code]]b", "<body>a[code]b</body>"},
+		{"a[code/]b", "<body>a<code></code>b</body>"},
+		{"a[codex]b", "<body>a[codex]b</body>"},
+	}
+	for _, test := range tests {
+		src, verbatim := ExtractVerbatim(test.html)
+		tree := parseHtmlBody(src, t)
+		if err := ProcessShortcodes(tree); err != nil {
+			t.Errorf("%q: shortcode processing error: %s", test.html, err.Error())
+			continue
+		}
+		RestoreVerbatim(tree, verbatim)
+		got := renderHtml(tree, t)
+		if got != test.want {
+			t.Errorf("%q: want %q but got %q", test.html, test.want, got)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package wxr
+
+import (
+	"encoding/xml"
+	"io"
+)
+
+const wpNamespace = "http://wordpress.org/export/1.2/"
+
+// Decoder reads a WXR export one item at a time, so large exports don't
+// have to be held in memory in their entirety.
+//
+// Channel-level data (title, authors, categories etc.) is collected in
+// Channel as it is encountered. Exports list it before the items, so it
+// is complete by the time the first item is returned. Channel.Items is
+// never filled in.
+type Decoder struct {
+	Channel Channel
+
+	d         *xml.Decoder
+	inChannel bool
+}
+
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{d: xml.NewDecoder(r)}
+}
+
+// Returns the next item in the export, or io.EOF at the end of the input.
+func (d *Decoder) Next() (*Item, error) {
+	for {
+		tok, err := d.d.Token()
+		if err != nil {
+			return nil, err
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			if !d.inChannel {
+				// descend into rss/channel, ignore everything else
+				d.inChannel = t.Name.Local == "channel"
+				continue
+			}
+
+			if t.Name.Space == "" && t.Name.Local == "item" {
+				item := new(Item)
+				if err = d.d.DecodeElement(item, &t); err != nil {
+					return nil, err
+				}
+				return item, nil
+			}
+
+			if err = d.decodeChannelElement(&t); err != nil {
+				return nil, err
+			}
+
+		case xml.EndElement:
+			if t.Name.Local == "channel" {
+				d.inChannel = false
+			}
+		}
+	}
+}
+
+func (d *Decoder) decodeChannelElement(start *xml.StartElement) error {
+	c := &d.Channel
+	switch start.Name {
+	case xml.Name{Space: "", Local: "title"}:
+		return d.d.DecodeElement(&c.Title, start)
+	case xml.Name{Space: "", Local: "link"}:
+		return d.d.DecodeElement(&c.Link, start)
+	case xml.Name{Space: wpNamespace, Local: "base_blog_url"}:
+		return d.d.DecodeElement(&c.BaseBlogUrl, start)
+	case xml.Name{Space: wpNamespace, Local: "author"}:
+		author := new(Author)
+		c.Authors = append(c.Authors, author)
+		return d.d.DecodeElement(author, start)
+	case xml.Name{Space: wpNamespace, Local: "category"}:
+		cat := new(Category)
+		c.Categories = append(c.Categories, cat)
+		return d.d.DecodeElement(cat, start)
+	case xml.Name{Space: wpNamespace, Local: "tag"}:
+		tag := new(Tag)
+		c.Tags = append(c.Tags, tag)
+		return d.d.DecodeElement(tag, start)
+	}
+	return d.d.Skip()
+}
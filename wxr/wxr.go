@@ -9,8 +9,9 @@ type Channel struct {
 	Title       string      `xml:"title"`
 	Link        string      `xml:"link"`
 	BaseBlogUrl string      `xml:"http://wordpress.org/export/1.2/ base_blog_url"`
-	Authors     []*Author   `xml:"http://wordpress.org/export/1.2/ wp_author"`
+	Authors     []*Author   `xml:"http://wordpress.org/export/1.2/ author"`
 	Categories  []*Category `xml:"http://wordpress.org/export/1.2/ category"`
+	Tags        []*Tag      `xml:"http://wordpress.org/export/1.2/ tag"`
 	Items       []*Item     `xml:"item"`
 }
 
@@ -29,32 +30,56 @@ type Category struct {
 	Name     string `xml:"cat_name"`
 }
 
+type Tag struct {
+	TermId int    `xml:"term_id"`
+	Slug   string `xml:"tag_slug"`
+	Name   string `xml:"tag_name"`
+}
+
+// Category or tag reference on an item. Domain is "category" for
+// categories and "post_tag" for tags.
+type ItemCategory struct {
+	Domain   string `xml:"domain,attr"`
+	NiceName string `xml:"nicename,attr"`
+	Name     string `xml:",chardata"`
+}
+
 type Item struct {
-	Title         string     `xml:"title"`
-	Link          string     `xml:"link"`
-	Content       []byte     `xml:"http://purl.org/rss/1.0/modules/content/ encoded"`
-	PostId        int        `xml:"http://wordpress.org/export/1.2/ post_id"`
-	PostDateGmt   string     `xml:"http://wordpress.org/export/1.2/ post_date_gmt"`
-	PostName      string     `xml:"http://wordpress.org/export/1.2/ post_name"`
-	PostType      string     `xml:"http://wordpress.org/export/1.2/ post_type"`
-	PostParent    int        `xml:"http://wordpress.org/export/1.2/ post_parent"`
-	CommentStatus string     `xml:"http://wordpress.org/export/1.2/ comment_status"`
-	Status        string     `xml:"http://wordpress.org/export/1.2/ status"`
-	IsSticky      int        `xml:"http://wordpress.org/export/1.2/ is_sticky"`
-	Comments      []*Comment `xml:"http://wordpress.org/export/1.2/ comment"`
-	Categories    []string   `xml:"category"`
-	AttachmentUrl string     `xml:"http://wordpress.org/export/1.2/ attachment_url"`
+	Title         string          `xml:"title"`
+	Link          string          `xml:"link"`
+	Creator       string          `xml:"http://purl.org/dc/elements/1.1/ creator"`
+	Content       []byte          `xml:"http://purl.org/rss/1.0/modules/content/ encoded"`
+	Excerpt       []byte          `xml:"http://wordpress.org/export/1.2/excerpt/ encoded"`
+	PostId        int             `xml:"http://wordpress.org/export/1.2/ post_id"`
+	PostDate      string          `xml:"http://wordpress.org/export/1.2/ post_date"`
+	PostDateGmt   string          `xml:"http://wordpress.org/export/1.2/ post_date_gmt"`
+	PostName      string          `xml:"http://wordpress.org/export/1.2/ post_name"`
+	PostType      string          `xml:"http://wordpress.org/export/1.2/ post_type"`
+	PostParent    int             `xml:"http://wordpress.org/export/1.2/ post_parent"`
+	CommentStatus string          `xml:"http://wordpress.org/export/1.2/ comment_status"`
+	Status        string          `xml:"http://wordpress.org/export/1.2/ status"`
+	IsSticky      int             `xml:"http://wordpress.org/export/1.2/ is_sticky"`
+	Comments      []*Comment      `xml:"http://wordpress.org/export/1.2/ comment"`
+	Categories    []*ItemCategory `xml:"category"`
+	AttachmentUrl string          `xml:"http://wordpress.org/export/1.2/ attachment_url"`
+	PostMeta      []*PostMeta     `xml:"http://wordpress.org/export/1.2/ postmeta"`
+}
+
+type PostMeta struct {
+	Key   string `xml:"meta_key"`
+	Value string `xml:"meta_value"`
 }
 
 type Comment struct {
-	Id        int    `xml:"comment_id"`
-	Author    string `xml:"comment_author"`
-	AuthorUrl string `xml:"comment_author_url"`
-	AuthorIp  string `xml:"comment_author_ip"`
-	DateGmt   string `xml:"comment_date_gmt"`
-	Content   string `xml:"comment_content"`
-	Approved  string `xml:"comment_approved"`
-	Type      string `xml:"comment_type"`
-	Parent    int    `xml:"comment_parent"`
-	UserId    int    `xml:"comment_user_id"`
+	Id          int    `xml:"comment_id"`
+	Author      string `xml:"comment_author"`
+	AuthorEmail string `xml:"comment_author_email"`
+	AuthorUrl   string `xml:"comment_author_url"`
+	AuthorIp    string `xml:"comment_author_IP"`
+	DateGmt     string `xml:"comment_date_gmt"`
+	Content     string `xml:"comment_content"`
+	Approved    string `xml:"comment_approved"`
+	Type        string `xml:"comment_type"`
+	Parent      int    `xml:"comment_parent"`
+	UserId      int    `xml:"comment_user_id"`
 }